@@ -10,14 +10,18 @@ import (
 	"crypto/rand"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/btcsuite/btcutil/base58"
 	"github.com/minio/cli"
 	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/zeebo/errs"
@@ -43,7 +47,55 @@ type GatewayFlags struct {
 
 	Config
 
-	Website bool `help:"serve content as a static website" default:"false" basic-help:"true"`
+	Website    bool   `help:"serve content as a static website" default:"false" basic-help:"true"`
+	WebsiteTag string `help:"serve only objects with this tag (key=value) as static website content" default:""`
+
+	ReopenWait time.Duration `help:"how long requests wait for a project reopen (triggered by SIGHUP) to finish before they are rejected" default:"0s"`
+
+	BucketCacheTTL time.Duration `help:"how long the existence of buckets is cached, buckets created or deleted elsewhere are seen after this time, disabled if zero" default:"0s"`
+
+	Webhook     miniogw.WebhookConfig
+	Index       miniogw.IndexConfig
+	Mirror      miniogw.MirrorConfig
+	Replica     miniogw.ReplicaConfig
+	Concurrency miniogw.ConcurrencyConfig
+	Usage       miniogw.UsageConfig
+	Readahead   miniogw.ReadaheadConfig
+	SlowStart   miniogw.SlowStartConfig
+
+	EncryptionPaths string `help:"comma separated bucket/prefix/ paths clients may select to read objects under with the X-Storj-Encryption-Path header" default:""`
+
+	AccessLogs        string        `help:"comma separated source-bucket=target-bucket/prefix pairs to write server access logs for" default:""`
+	AccessLogInterval time.Duration `help:"how often the server access logs are written to the target buckets" default:"5m"`
+	AccessLogFile     string        `help:"file to save the server access logging configurations of buckets to, so they persist across restarts" default:""`
+	AccessLogAddress  string        `help:"address to serve the bucket logging configuration endpoint on (GET/PUT ?bucket=... with S3 BucketLoggingStatus XML), it takes the gateway access and secret key as basic auth in clear text and must only be bound to loopback, disabled if empty" default:""`
+
+	StrictDeleteObjects bool `help:"reject multi-object delete requests without any object as malformed, as S3 does" default:"false"`
+
+	DedupBucket string `help:"existing bucket to store uploads with a signed payload once per content hash (experimental, stored content is never deleted)" default:""`
+
+	IsolateAccessKeys bool `help:"confine object keys to a namespace per access key within the buckets" default:"false"`
+
+	AbortUploadsOnBucketDelete bool `help:"abort pending multipart uploads to deleted buckets instead of rejecting the deletion" default:"false"`
+	AllowPartNumberGaps        bool `help:"allow multipart uploads to skip part numbers, which requires clients to upload the parts in ascending order" default:"false"`
+	MaxParts                   int  `help:"maximum number of parts of multipart uploads, uploads exceeding it are aborted, unlimited if zero" default:"10000"`
+
+	VerifyUploads bool `help:"read uploaded objects back and fail uploads whose stored content doesn't match, at the cost of downloading and copying each upload" default:"false"`
+
+	ObjectAliases bool `help:"serve the target object for alias objects uploaded with the X-Amz-Meta-Alias-Target metadata header" default:"false"`
+
+	DenyReservedKeys bool   `help:"deny client requests to keys in reserved prefixes, like the index prefix, and to the deduplication bucket" default:"false"`
+	ReservedPrefixes string `help:"comma separated additional key prefixes reserved in each bucket" default:""`
+
+	BucketAliases string `help:"comma separated alias=bucket pairs of bucket names whose object requests are served from other buckets, e.g. old names of renamed buckets" default:""`
+
+	BucketMetadata string `help:"comma separated bucket/key=value default metadata of objects uploaded to buckets, which clients can override, e.g. photos/X-Amz-Meta-Project=album" default:""`
+
+	ControlKeys string `help:"how object keys with control characters, e.g. null bytes, are handled: allow them as they are, reject them or encode them percent-encoded" default:"allow"`
+
+	ExpirationRuleID string `help:"rule id reported in the x-amz-expiration header of objects with an expiry, the header is omitted if empty" default:""`
+
+	KeyRewrites string `help:"comma separated old=new object key prefix pairs, ending with a slash, of keys clients use which are stored with the new prefix instead, e.g. for objects migrated from a system with a different key scheme" default:""`
 }
 
 var (
@@ -225,12 +277,62 @@ func (flags GatewayFlags) action(ctx context.Context, cliCtx *cli.Context) (err
 		return err
 	}
 
+	go reopenOnHangup(ctx, gw)
+
+	if flags.Index.Address != "" {
+		go serveIndex(flags.Index.Address, gw)
+	}
+
+	if flags.AccessLogAddress != "" {
+		go serveAccessLogs(flags.AccessLogAddress, gw, auth.Credentials{
+			AccessKey: flags.Minio.AccessKey,
+			SecretKey: flags.Minio.SecretKey,
+		})
+	}
+
 	minio.StartGateway(cliCtx, miniogw.Logging(gw, zap.L()))
 	return errs.New("unexpected minio exit")
 }
 
+// serveIndex serves the metadata index query endpoint of the gateway.
+func serveIndex(address string, gw *miniogw.Gateway) {
+	zap.S().Infof("Serving metadata index queries on %s\n", address)
+	if err := http.ListenAndServe(address, gw.IndexHandler()); err != nil {
+		zap.S().Error("Failed to serve metadata index queries: ", err)
+	}
+}
+
+// serveAccessLogs serves the bucket logging configuration endpoint of the
+// gateway.
+func serveAccessLogs(address string, gw *miniogw.Gateway, credentials auth.Credentials) {
+	zap.S().Infof("Serving bucket logging configuration on %s\n", address)
+	if err := http.ListenAndServe(address, gw.AccessLogHandler(credentials)); err != nil {
+		zap.S().Error("Failed to serve bucket logging configuration: ", err)
+	}
+}
+
+// reopenOnHangup reopens the projects of the gateway whenever the process
+// receives SIGHUP.
+func reopenOnHangup(ctx context.Context, gw *miniogw.Gateway) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			zap.S().Info("Reopening project")
+			if err := gw.ReopenProjects(ctx); err != nil {
+				zap.S().Warn("Failed to reopen project: ", err)
+			}
+		}
+	}
+}
+
 // NewGateway creates a new minio Gateway
-func (flags GatewayFlags) NewGateway(ctx context.Context) (gw minio.Gateway, err error) {
+func (flags GatewayFlags) NewGateway(ctx context.Context) (gw *miniogw.Gateway, err error) {
 	access, err := flags.GetAccess()
 	if err != nil {
 		return nil, Error.Wrap(err)
@@ -238,7 +340,163 @@ func (flags GatewayFlags) NewGateway(ctx context.Context) (gw minio.Gateway, err
 
 	config := flags.newUplinkConfig(ctx)
 
-	return miniogw.NewStorjGateway(access, config, flags.Website), nil
+	aliases, err := flags.bucketAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := flags.bucketMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	rewrites, err := flags.keyRewrites()
+	if err != nil {
+		return nil, err
+	}
+
+	switch flags.ControlKeys {
+	case "allow", miniogw.ControlKeysReject, miniogw.ControlKeysEncode:
+	default:
+		return nil, Error.New("invalid handling of object keys with control characters %q", flags.ControlKeys)
+	}
+
+	gw = miniogw.NewStorjGateway(access, config, miniogw.Config{
+		Website:                    flags.Website,
+		WebsiteTag:                 flags.WebsiteTag,
+		ReopenWait:                 flags.ReopenWait,
+		DedupBucket:                flags.DedupBucket,
+		Webhook:                    flags.Webhook,
+		Index:                      flags.Index,
+		EncryptionPaths:            flags.encryptionPaths(),
+		AccessLogInterval:          flags.AccessLogInterval,
+		AccessLogFile:              flags.AccessLogFile,
+		Mirror:                     flags.Mirror,
+		StrictDeleteObjects:        flags.StrictDeleteObjects,
+		IsolateAccessKeys:          flags.IsolateAccessKeys,
+		AbortUploadsOnBucketDelete: flags.AbortUploadsOnBucketDelete,
+		Replica:                    flags.Replica,
+		Concurrency:                flags.Concurrency,
+		BucketAliases:              aliases,
+		VerifyUploads:              flags.VerifyUploads,
+		ObjectAliases:              flags.ObjectAliases,
+		DenyReservedKeys:           flags.DenyReservedKeys,
+		ReservedPrefixes:           flags.reservedPrefixes(),
+		Usage:                      flags.Usage,
+		BucketCacheTTL:             flags.BucketCacheTTL,
+		Readahead:                  flags.Readahead,
+		BucketMetadata:             metadata,
+		AllowPartNumberGaps:        flags.AllowPartNumberGaps,
+		MaxParts:                   flags.MaxParts,
+		ControlKeys:                flags.ControlKeys,
+		SlowStart:                  flags.SlowStart,
+		ExpirationRuleID:           flags.ExpirationRuleID,
+		KeyRewrites:                rewrites,
+	})
+
+	if err := gw.LoadBucketLogging(); err != nil {
+		return nil, err
+	}
+
+	for _, pair := range strings.Split(flags.AccessLogs, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		source, target := splitPair(pair, "=")
+		targetBucket, targetPrefix := splitPair(target, "/")
+		if source == "" || targetBucket == "" {
+			return nil, Error.New("invalid access log configuration %q", pair)
+		}
+		err := gw.PutBucketLogging(source, &miniogw.BucketLogging{
+			TargetBucket: targetBucket,
+			TargetPrefix: targetPrefix,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return gw, nil
+}
+
+// splitPair splits s around the first separator.
+func splitPair(s, separator string) (string, string) {
+	parts := strings.SplitN(s, separator, 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// encryptionPaths returns the configured encryption paths clients may select.
+func (flags *GatewayFlags) encryptionPaths() (paths []string) {
+	for _, path := range strings.Split(flags.EncryptionPaths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// reservedPrefixes returns the configured additional reserved key prefixes.
+func (flags *GatewayFlags) reservedPrefixes() (prefixes []string) {
+	for _, prefix := range strings.Split(flags.ReservedPrefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// bucketAliases returns the configured bucket aliases.
+func (flags *GatewayFlags) bucketAliases() (map[string]string, error) {
+	aliases := make(map[string]string)
+	for _, pair := range strings.Split(flags.BucketAliases, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		alias, bucket := splitPair(pair, "=")
+		if alias == "" || bucket == "" || alias == bucket {
+			return nil, Error.New("invalid bucket alias %q", pair)
+		}
+		aliases[alias] = bucket
+	}
+	return aliases, nil
+}
+
+// bucketMetadata returns the configured default metadata per bucket.
+func (flags *GatewayFlags) bucketMetadata() (map[string]map[string]string, error) {
+	metadata := make(map[string]map[string]string)
+	for _, pair := range strings.Split(flags.BucketMetadata, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		path, value := splitPair(pair, "=")
+		bucket, key := splitPair(path, "/")
+		if bucket == "" || key == "" {
+			return nil, Error.New("invalid bucket metadata %q", pair)
+		}
+		if metadata[bucket] == nil {
+			metadata[bucket] = make(map[string]string)
+		}
+		metadata[bucket][key] = value
+	}
+	return metadata, nil
+}
+
+// keyRewrites returns the configured object key rewrites.
+func (flags *GatewayFlags) keyRewrites() (rewrites []miniogw.KeyRewrite, err error) {
+	for _, pair := range strings.Split(flags.KeyRewrites, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		from, to := splitPair(pair, "=")
+		if from == "" || to == "" || from == to {
+			return nil, Error.New("invalid key rewrite %q", pair)
+		}
+		rewrites = append(rewrites, miniogw.KeyRewrite{Old: from, New: to})
+	}
+	return rewrites, nil
 }
 
 func (flags *GatewayFlags) newUplinkConfig(ctx context.Context) uplink.Config {
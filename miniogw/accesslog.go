@@ -0,0 +1,358 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	miniov6 "github.com/minio/minio-go/v6"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+	"github.com/zeebo/errs"
+
+	"storj.io/common/uuid"
+	"storj.io/uplink"
+)
+
+// accessLogBatchSize is the number of entries after which the access logs of
+// a target are flushed regardless of the flush interval.
+const accessLogBatchSize = 1000
+
+// BucketLogging is the server access logging configuration of a bucket.
+type BucketLogging struct {
+	// TargetBucket is the bucket to write the access log objects to.
+	TargetBucket string
+	// TargetPrefix is prepended to the keys of the access log objects.
+	TargetPrefix string
+}
+
+// bucketLoggingStatus is the S3 XML representation of the server access
+// logging configuration of a bucket.
+type bucketLoggingStatus struct {
+	XMLName        xml.Name       `xml:"BucketLoggingStatus"`
+	LoggingEnabled *BucketLogging `xml:"LoggingEnabled"`
+}
+
+// accessLogs collects access log entries and writes them as objects into
+// the target buckets.
+type accessLogs struct {
+	mu      sync.Mutex
+	configs map[string]BucketLogging
+	entries map[BucketLogging][]string
+}
+
+// LoadBucketLogging loads the server access logging configurations saved to
+// the configured access log file, if it exists.
+func (gateway *Gateway) LoadBucketLogging() error {
+	file := gateway.config.AccessLogFile
+	if file == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	var configs map[string]BucketLogging
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return Error.New("invalid access log file %q: %v", file, err)
+	}
+
+	logs := &gateway.accessLogs
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	logs.configs = configs
+	return nil
+}
+
+// PutBucketLogging enables server access logging for the bucket, or disables
+// it if config is nil. The configurations are saved to the access log file,
+// if one is configured, and only changed if saving them succeeds.
+//
+// Minio doesn't route the S3 bucket logging API to gateways, so it's served
+// by AccessLogHandler instead.
+func (gateway *Gateway) PutBucketLogging(bucketName string, config *BucketLogging) error {
+	logs := &gateway.accessLogs
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+
+	configs := make(map[string]BucketLogging, len(logs.configs)+1)
+	for bucket, config := range logs.configs {
+		configs[bucket] = config
+	}
+	if config == nil {
+		delete(configs, bucketName)
+	} else {
+		configs[bucketName] = *config
+	}
+
+	if file := gateway.config.AccessLogFile; file != "" {
+		data, err := json.Marshal(configs)
+		if err != nil {
+			return Error.Wrap(err)
+		}
+		// write a new file and rename it, so that a failed write doesn't
+		// lose the previous configurations
+		if err := ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+			return Error.Wrap(err)
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			return Error.Wrap(err)
+		}
+	}
+
+	logs.configs = configs
+	return nil
+}
+
+// GetBucketLogging returns the server access logging configuration of the
+// bucket, or nil if logging is disabled.
+func (gateway *Gateway) GetBucketLogging(bucketName string) *BucketLogging {
+	logs := &gateway.accessLogs
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+
+	config, ok := logs.configs[bucketName]
+	if !ok {
+		return nil
+	}
+	return &config
+}
+
+// AccessLogHandler returns the handler of the bucket logging endpoint. It
+// responds to "GET ?bucket=..." with the S3 BucketLoggingStatus XML of the
+// bucket, and "PUT ?bucket=..." sets it from the BucketLoggingStatus XML in
+// the body. A BucketLoggingStatus without LoggingEnabled disables logging.
+//
+// The logging configuration decides which buckets the access logs are
+// written to, so requests have to authenticate with the credentials of the
+// gateway as HTTP basic auth. They are sent in clear text, so the endpoint
+// must only be served on a loopback address.
+func (gateway *Gateway) AccessLogHandler(credentials auth.Credentials) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKey, secretKey, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(accessKey), []byte(credentials.AccessKey)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(secretKey), []byte(credentials.SecretKey)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bucket logging"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		bucket := r.URL.Query().Get("bucket")
+		if bucket == "" {
+			http.Error(w, "bucket is required", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			status := bucketLoggingStatus{LoggingEnabled: gateway.GetBucketLogging(bucket)}
+			w.Header().Set("Content-Type", "application/xml")
+			_ = xml.NewEncoder(w).Encode(status)
+		case http.MethodPut:
+			var status bucketLoggingStatus
+			if err := xml.NewDecoder(r.Body).Decode(&status); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if status.LoggingEnabled != nil && status.LoggingEnabled.TargetBucket == "" {
+				http.Error(w, "TargetBucket is required", http.StatusBadRequest)
+				return
+			}
+			if err := gateway.PutBucketLogging(bucket, status.LoggingEnabled); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// logAccess records an access log entry for the operation on the object, if
+// logging is enabled for the bucket. The entries follow the S3 server access
+// log format, with fields unknown to the gateway set to "-".
+func (gateway *Gateway) logAccess(ctx context.Context, operation, bucketName, objectPath string, size int64, err error) {
+	logs := &gateway.accessLogs
+	logs.mu.Lock()
+	config, ok := logs.configs[bucketName]
+	logs.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	remote, requestID, userAgent := "-", "-", "-"
+	if info := logger.GetReqInfo(ctx); info != nil {
+		remote = orDash(info.RemoteHost)
+		requestID = orDash(info.RequestID)
+		userAgent = orDash(info.UserAgent)
+	}
+
+	status, errorCode := "200", "-"
+	if err != nil {
+		status, errorCode = accessLogError(err)
+	}
+
+	objectSize := "-"
+	if err == nil && size >= 0 {
+		objectSize = fmt.Sprint(size)
+	}
+
+	// the keys are URL encoded as in S3, so they don't contain spaces
+	// separating the fields
+	entry := fmt.Sprintf("- %s [%s] %s - %s %s %s \"-\" %s %s - %s - - \"-\" %q -",
+		bucketName, time.Now().UTC().Format("02/Jan/2006:15:04:05 -0700"),
+		remote, requestID, operation, orDash(url.PathEscape(objectPath)),
+		status, orDash(errorCode), objectSize, userAgent)
+
+	logs.mu.Lock()
+	if logs.entries == nil {
+		logs.entries = make(map[BucketLogging][]string)
+	}
+	logs.entries[config] = append(logs.entries[config], entry)
+	full := len(logs.entries[config]) >= accessLogBatchSize
+	logs.mu.Unlock()
+
+	if full {
+		go func() { _ = gateway.FlushAccessLogs(context.Background()) }()
+	}
+}
+
+// FlushAccessLogs writes the collected access log entries as objects into the
+// target buckets. Entries that fail to be written are dropped.
+func (gateway *Gateway) FlushAccessLogs(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	logs := &gateway.accessLogs
+	logs.mu.Lock()
+	entries := logs.entries
+	logs.entries = nil
+	logs.mu.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	project, err := gateway.uplinkConfig.OpenProject(ctx, gateway.access)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, project.Close()) }()
+
+	var group errs.Group
+	for config, lines := range entries {
+		group.Add(writeAccessLog(ctx, project, config, lines))
+	}
+	return group.Err()
+}
+
+// writeAccessLog writes the access log entries as a new object into the
+// target bucket of config.
+func writeAccessLog(ctx context.Context, project *uplink.Project, config BucketLogging, lines []string) error {
+	id, err := uuid.New()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	key := config.TargetPrefix + time.Now().UTC().Format("2006-01-02-15-04-05-") + strings.ToUpper(id.String()[:16])
+
+	upload, err := project.UploadObject(ctx, config.TargetBucket, key, nil)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	_, err = upload.Write(buf.Bytes())
+	if err != nil {
+		return Error.Wrap(errs.Combine(err, upload.Abort()))
+	}
+	return Error.Wrap(upload.Commit())
+}
+
+// accessLogError returns the HTTP status and the S3 error code of the
+// response to a request that failed with err.
+func accessLogError(err error) (status, code string) {
+	if response := miniov6.ToErrorResponse(err); response.Code != "" {
+		status = "-"
+		if response.StatusCode != 0 {
+			status = fmt.Sprint(response.StatusCode)
+		}
+		return status, response.Code
+	}
+
+	switch err.(type) {
+	case minio.BucketNotFound:
+		return "404", "NoSuchBucket"
+	case minio.ObjectNotFound:
+		return "404", "NoSuchKey"
+	case minio.InvalidUploadID:
+		return "404", "NoSuchUpload"
+	case minio.BucketNameInvalid:
+		return "400", "InvalidBucketName"
+	case minio.ObjectNameInvalid:
+		return "400", "XMinioInvalidObjectName"
+	case minio.InvalidPart:
+		return "400", "InvalidPart"
+	case minio.InvalidRange:
+		return "416", "InvalidRange"
+	case minio.BucketAlreadyExists:
+		return "409", "BucketAlreadyExists"
+	case minio.BucketNotEmpty:
+		return "409", "BucketNotEmpty"
+	case minio.PrefixAccessDenied:
+		return "403", "AccessDenied"
+	case minio.InsufficientReadQuorum:
+		return "503", "SlowDown"
+	case minio.NotImplemented:
+		return "501", "NotImplemented"
+	}
+	return "500", "InternalError"
+}
+
+// flushAccessLogsEvery flushes the access logs periodically until the gateway
+// is closed.
+func (gateway *Gateway) flushAccessLogsEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := gateway.FlushAccessLogs(context.Background()); err != nil {
+				mon.Counter("access_log_flush_failed").Inc(1)
+			}
+		case <-gateway.closed:
+			return
+		}
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
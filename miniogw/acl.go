@@ -0,0 +1,59 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"encoding/xml"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+// allUsersURI is the grantee URI of the group of all users, i.e. anonymous
+// access.
+const allUsersURI = "http://acs.amazonaws.com/groups/global/AllUsers"
+
+// accessControlPolicy is the ACL of an object as minio encodes it.
+type accessControlPolicy struct {
+	XMLName xml.Name    `xml:"AccessControlPolicy"`
+	Owner   minio.Owner `xml:"Owner"`
+	Grants  []aclGrant  `xml:"AccessControlList>Grant"`
+}
+
+type aclGrant struct {
+	Grantee    aclGrantee `xml:"Grantee"`
+	Permission string     `xml:"Permission"`
+}
+
+type aclGrantee struct {
+	XMLNS  string `xml:"xmlns:xsi,attr"`
+	XMLXSI string `xml:"xsi:type,attr"`
+	Type   string `xml:"Type"`
+	URI    string `xml:"URI,omitempty"`
+}
+
+// publicReadACL is the canned public-read ACL of objects anonymous requests
+// may read in website mode. Minio answers object ACL requests with the
+// owner-only grant of the private canned ACL, so responseWriter replaces its
+// response with this one for those objects.
+var publicReadACL = accessControlPolicy{
+	Grants: []aclGrant{
+		{
+			Grantee: aclGrantee{
+				XMLNS:  "http://www.w3.org/2001/XMLSchema-instance",
+				XMLXSI: "CanonicalUser",
+				Type:   "CanonicalUser",
+			},
+			Permission: "FULL_CONTROL",
+		},
+		{
+			Grantee: aclGrantee{
+				XMLNS:  "http://www.w3.org/2001/XMLSchema-instance",
+				XMLXSI: "Group",
+				Type:   "Group",
+				URI:    allUsersURI,
+			},
+			Permission: "READ",
+		},
+	},
+}
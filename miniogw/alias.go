@@ -0,0 +1,24 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+// bucketAliases resolves alias bucket names, e.g. the old names of renamed
+// buckets, to the buckets they refer to for object requests. The responses
+// refer to the bucket by the name the client used.
+//
+// Creating and deleting buckets isn't resolved, so an alias can't be used to
+// delete the bucket it refers to.
+type bucketAliases struct {
+	identityMapping
+	aliases map[string]string
+}
+
+// bucket returns the name of the bucket the client addresses with bucket.
+func (alias *bucketAliases) bucket(bucket string) string {
+	if target, ok := alias.aliases[bucket]; ok {
+		mon.Counter("bucket_alias_resolved").Inc(1)
+		return target
+	}
+	return bucket
+}
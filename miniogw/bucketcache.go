@@ -0,0 +1,102 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"storj.io/uplink"
+)
+
+// bucketCache caches whether buckets exist for the configured time, so that
+// object requests don't need to check it with the satellite every time.
+// Buckets created or deleted with the gateway are invalidated immediately,
+// while changes made elsewhere are seen once the entries expire.
+type bucketCache struct {
+	mu      sync.Mutex
+	entries map[string]bucketCacheEntry
+	// generation is incremented by every invalidation, so that results of
+	// checks started before it are not cached.
+	generation uint64
+}
+
+// bucketCacheEntry is the cached result of checking a bucket.
+type bucketCacheEntry struct {
+	err     error
+	expires time.Time
+}
+
+// get returns the cached result of checking the bucket, if it's cached, and
+// the current generation of the cache.
+func (cache *bucketCache) get(bucketName string, now time.Time) (_ bucketCacheEntry, generation uint64, ok bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[bucketName]
+	if !ok || now.After(entry.expires) {
+		return bucketCacheEntry{}, cache.generation, false
+	}
+	return entry, cache.generation, true
+}
+
+// put caches the result of checking the bucket until expires, unless the
+// cache was invalidated since generation.
+func (cache *bucketCache) put(bucketName string, err error, expires time.Time, generation uint64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if generation != cache.generation {
+		return
+	}
+	if cache.entries == nil {
+		cache.entries = make(map[string]bucketCacheEntry)
+	}
+	cache.entries[bucketName] = bucketCacheEntry{err: err, expires: expires}
+}
+
+// invalidate removes the cached result of checking the bucket.
+func (cache *bucketCache) invalidate(bucketName string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.generation++
+	delete(cache.entries, bucketName)
+}
+
+// clear removes all cached results.
+func (cache *bucketCache) clear() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.generation++
+	cache.entries = nil
+}
+
+// statBucket checks that the bucket exists, using the cached result if the
+// bucket cache is enabled. Only existing and missing buckets are cached,
+// other errors are always returned from the satellite.
+func (layer *gatewayLayer) statBucket(ctx context.Context, project *uplink.Project, bucketName string) (err error) {
+	ttl := layer.gateway.config.BucketCacheTTL
+	if ttl <= 0 {
+		_, err = project.StatBucket(ctx, bucketName)
+		return err
+	}
+
+	now := time.Now()
+	entry, generation, ok := layer.buckets.get(bucketName, now)
+	if ok {
+		mon.Counter("bucket_cache_hit").Inc(1)
+		return entry.err
+	}
+	mon.Counter("bucket_cache_miss").Inc(1)
+
+	_, err = project.StatBucket(ctx, bucketName)
+	if err == nil || errors.Is(err, uplink.ErrBucketNotFound) {
+		layer.buckets.put(bucketName, err, now.Add(ttl), generation)
+	}
+	return err
+}
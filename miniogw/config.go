@@ -3,6 +3,8 @@
 
 package miniogw
 
+import "time"
+
 // MinioConfig is a configuration struct that keeps details about starting
 // Minio
 type MinioConfig struct {
@@ -15,3 +17,112 @@ type MinioConfig struct {
 type ServerConfig struct {
 	Address string `help:"address to serve S3 api over" default:"127.0.0.1:7777" basic-help:"true"`
 }
+
+// Config contains the configuration of the gateway layer.
+type Config struct {
+	// Website allows anonymous read access to all buckets.
+	Website bool
+	// WebsiteTag restricts the anonymous access to the content of objects
+	// tagged with this "key=value" tag.
+	WebsiteTag string
+	// ReopenWait is how long requests wait for a project reopen to finish
+	// before they are rejected with a retryable error.
+	ReopenWait time.Duration
+	// DedupBucket enables deduplication of uploads by content hash. The
+	// content is stored once in this bucket, which has to exist, and objects
+	// only reference it.
+	DedupBucket string
+	// Webhook configures notifications about created and deleted objects.
+	Webhook WebhookConfig
+	// Index configures the index of objects by metadata for search.
+	Index IndexConfig
+	// EncryptionPaths lists the "bucket/prefix/" paths clients may select
+	// to read objects under with the X-Storj-Encryption-Path header.
+	EncryptionPaths []string
+	// AccessLogInterval is how often the server access logs of buckets with
+	// logging enabled are written to their target buckets.
+	AccessLogInterval time.Duration
+	// AccessLogFile is the file the server access logging configurations of
+	// buckets are saved to, they aren't persisted if it's empty.
+	AccessLogFile string
+	// Mirror configures mirroring of uploaded objects into another project.
+	Mirror MirrorConfig
+	// StrictDeleteObjects rejects requests to delete multiple objects
+	// without any object in their body with MalformedXML, as S3 does,
+	// instead of responding with an empty result. Requests whose objects
+	// are all denied still get an error per object.
+	StrictDeleteObjects bool
+	// Transforms are applied in order to the content of uploaded objects
+	// and reverted in reverse order when the objects are downloaded.
+	Transforms []Transform
+	// IsolateAccessKeys confines the object keys of each access key to its
+	// own namespace within the buckets, so tenants sharing a bucket can't
+	// see each other's objects. The access key of each request is used, and
+	// requests without one, e.g. anonymous requests, are denied.
+	IsolateAccessKeys bool
+	// AbortUploadsOnBucketDelete aborts the pending multipart uploads to
+	// a bucket when it's deleted, instead of rejecting the deletion with
+	// BucketNotEmpty.
+	AbortUploadsOnBucketDelete bool
+	// Replica configures reading objects from a replica project when reads
+	// from the primary project fail.
+	Replica ReplicaConfig
+	// Concurrency limits the concurrent object requests and shares them
+	// fairly between buckets.
+	Concurrency ConcurrencyConfig
+	// BucketAliases maps alias bucket names, e.g. the old names of renamed
+	// buckets, to the names of the buckets the object requests to them are
+	// served from.
+	BucketAliases map[string]string
+	// VerifyUploads reads uploaded objects back after they are committed and
+	// fails the uploads if their content doesn't match the uploaded content.
+	// The uploads are committed under a temporary key and copied into place
+	// once verified, so a failed upload keeps the previous object. Deduplicated
+	// uploads and multipart uploads aren't verified.
+	VerifyUploads bool
+	// ObjectAliases enables alias objects, which are uploaded with the
+	// X-Amz-Meta-Alias-Target metadata header set to the key of another
+	// object in the same bucket, whose content and info is served for them.
+	ObjectAliases bool
+	// DenyReservedKeys denies client requests to keys in the reserved
+	// prefixes, i.e. the prefix of the index and ReservedPrefixes, and to
+	// the deduplication bucket with AccessDenied.
+	DenyReservedKeys bool
+	// ReservedPrefixes are additional key prefixes reserved in each bucket,
+	// e.g. for state kept by other tools.
+	ReservedPrefixes []string
+	// Usage configures the export of the bandwidth used per access key.
+	Usage UsageConfig
+	// BucketCacheTTL is how long the existence of buckets checked by object
+	// requests is cached. Caching is disabled if zero.
+	BucketCacheTTL time.Duration
+	// Readahead configures prefetching the content of downloads.
+	Readahead ReadaheadConfig
+	// BucketMetadata maps bucket names to the default metadata of objects
+	// uploaded to them, which is added for the keys the client doesn't set.
+	BucketMetadata map[string]map[string]string
+	// AllowPartNumberGaps allows multipart uploads to skip part numbers, e.g.
+	// to consist of the parts 1, 5 and 9. The parts are streamed into the
+	// object as they arrive, so a part otherwise waits for the parts with
+	// all lower numbers. With gaps allowed, the waiting part with the lowest
+	// number is streamed next, so the clients have to upload the parts in
+	// ascending order, and parts arriving after a higher numbered one are
+	// rejected.
+	AllowPartNumberGaps bool
+	// MaxParts is the maximum number of parts of multipart uploads. Uploads
+	// exceeding it are aborted. The number of parts is unlimited if zero.
+	MaxParts int
+	// ControlKeys is how object keys with control characters, e.g. null
+	// bytes, are handled: ControlKeysReject or ControlKeysEncode. They are
+	// stored as they are otherwise.
+	ControlKeys string
+	// SlowStart configures ramping up the concurrent object requests after
+	// the project is opened or reopened.
+	SlowStart SlowStartConfig
+	// ExpirationRuleID is the rule id reported in the X-Amz-Expiration header
+	// of objects with an expiry. The header is omitted if empty.
+	ExpirationRuleID string
+	// KeyRewrites rewrite the object keys clients use to the stored keys,
+	// e.g. for objects migrated from a system with a different key scheme.
+	KeyRewrites []KeyRewrite
+}
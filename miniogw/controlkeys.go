@@ -0,0 +1,117 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	miniov6 "github.com/minio/minio-go/v6"
+)
+
+const (
+	// ControlKeysReject rejects object keys with control characters with
+	// InvalidArgument.
+	ControlKeysReject = "reject"
+	// ControlKeysEncode stores the control characters of object keys, and
+	// the "%" that start such an escape to keep the encoding reversible,
+	// percent-encoded, e.g. "a\x00b" as "a%00b" and "a%00b" as "a%2500b".
+	// Stored keys that already contain such escapes, e.g. "%0A" or "%25",
+	// are listed decoded and have to be renamed when enabling it.
+	ControlKeysEncode = "encode"
+)
+
+// hasControl reports whether the key contains a control character.
+func hasControl(key string) bool {
+	return strings.IndexFunc(key, isControl) >= 0
+}
+
+// isControl reports whether r is an ASCII control character.
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+// escapedControl returns the character that the escape at the start of s
+// decodes to, if it's a control character or "%".
+func escapedControl(s string) (byte, bool) {
+	if len(s) < 3 || s[0] != '%' {
+		return 0, false
+	}
+	c, err := hex.DecodeString(s[1:3])
+	if err != nil || !(isControl(rune(c[0])) || c[0] == '%') {
+		return 0, false
+	}
+	return c[0], true
+}
+
+// encodeControl percent-encodes the control characters of key, and the "%"
+// that start an escape decodeControl would decode, e.g. of "%00" or "%25".
+// Other "%" are kept as they are, e.g. of "100%.txt".
+func encodeControl(key string) string {
+	if !hasControl(key) && !strings.Contains(key, "%") {
+		return key
+	}
+
+	var encoded strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if _, escape := escapedControl(key[i:]); isControl(rune(c)) || escape {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+			continue
+		}
+		encoded.WriteByte(c)
+	}
+	return encoded.String()
+}
+
+// decodeControl reverts encodeControl. Other escapes are kept as they are,
+// as they can't have been produced by encodeControl.
+func decodeControl(key string) string {
+	if !strings.Contains(key, "%") {
+		return key
+	}
+
+	var decoded strings.Builder
+	for i := 0; i < len(key); i++ {
+		if c, ok := escapedControl(key[i:]); ok {
+			decoded.WriteByte(c)
+			i += 2
+			continue
+		}
+		decoded.WriteByte(key[i])
+	}
+	return decoded.String()
+}
+
+// controlKeys rejects or encodes object keys with control characters, e.g.
+// null bytes, which the storage and the XML responses of listings don't
+// handle well. Listed keys are decoded, so clients see the keys they used.
+type controlKeys struct {
+	identityMapping
+	encode bool
+}
+
+func (control *controlKeys) check(ctx context.Context, bucket, object string) error {
+	if !control.encode && hasControl(object) {
+		mon.Counter("control_key_rejected").Inc(1)
+		return miniov6.ErrInvalidArgument(fmt.Sprintf("object key %q contains control characters", object))
+	}
+	return nil
+}
+
+func (control *controlKeys) key(ctx context.Context, object string) string {
+	if control.encode {
+		return encodeControl(object)
+	}
+	return object
+}
+
+func (control *controlKeys) object(ctx context.Context, key string) string {
+	if control.encode {
+		return decodeControl(key)
+	}
+	return key
+}
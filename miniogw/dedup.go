@@ -0,0 +1,184 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+const (
+	// dedupKey is the custom metadata key of objects that reference
+	// deduplicated content. Its value is the key of the content in the
+	// deduplication bucket.
+	dedupKey = "s3:dedup"
+	// dedupSizeKey is the custom metadata key with the size of the
+	// referenced content.
+	dedupSizeKey = "s3:dedup-size"
+)
+
+// putDeduplicatedObject uploads the content of data to the deduplication
+// bucket under its SHA256 hash, unless content with the same hash is already
+// there, and stores only a reference to it under objectPath.
+//
+// The hash is the one declared by the client for the signed payload. The data
+// is still read in full to verify it, but when the content is known already,
+// it is not uploaded again.
+//
+// Caveats: the content in the deduplication bucket is never deleted, as there
+// is no reference counting, and objects are only deduplicated within the
+// project of the gateway.
+func (layer *gatewayLayer) putDeduplicatedObject(ctx context.Context, project *uplink.Project, bucketName, objectPath string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	dedupBucket := layer.gateway.config.DedupBucket
+	hash := data.SHA256HexString()
+
+	content, err := project.StatObject(ctx, dedupBucket, hash)
+	switch {
+	case errs.Is(err, uplink.ErrObjectNotFound):
+		content, err = uploadContent(ctx, project, dedupBucket, hash, data)
+		if err != nil {
+			return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+		}
+	case err != nil:
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	default:
+		// the content exists, so the data is only read to verify the hash
+		_, err = io.Copy(ioutil.Discard, data)
+		if err != nil {
+			return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+		}
+	}
+
+	upload, err := project.UploadObject(ctx, bucketName, objectPath, nil)
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	opts.UserDefined["s3:etag"] = hex.EncodeToString(data.MD5Current())
+	opts.UserDefined[dedupKey] = hash
+	opts.UserDefined[dedupSizeKey] = strconv.FormatInt(content.System.ContentLength, 10)
+	err = upload.SetCustomMetadata(ctx, opts.UserDefined)
+	if err != nil {
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	err = upload.Commit()
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	return minioObjectInfo(bucketName, opts.UserDefined["s3:etag"], upload.Info()), nil
+}
+
+// copyDeduplicatedObject stores a reference to the deduplicated content of
+// source under objectPath with the given metadata, so the content itself
+// isn't copied.
+func (layer *gatewayLayer) copyDeduplicatedObject(ctx context.Context, project *uplink.Project, source *uplink.Object, bucketName, objectPath string, metadata map[string]string) (objInfo minio.ObjectInfo, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	upload, err := project.UploadObject(ctx, bucketName, objectPath, nil)
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	// replacing metadata doesn't carry the ETag of the source
+	if etag, ok := source.Custom["s3:etag"]; ok {
+		metadata["s3:etag"] = etag
+	}
+	metadata[dedupKey] = source.Custom[dedupKey]
+	metadata[dedupSizeKey] = source.Custom[dedupSizeKey]
+	err = upload.SetCustomMetadata(ctx, metadata)
+	if err != nil {
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	err = upload.Commit()
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	return minioObjectInfo(bucketName, metadata["s3:etag"], upload.Info()), nil
+}
+
+// uploadContent uploads data to the deduplication bucket under key.
+func uploadContent(ctx context.Context, project *uplink.Project, bucketName, key string, data io.Reader) (_ *uplink.Object, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	upload, err := project.UploadObject(ctx, bucketName, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(upload, data)
+	if err != nil {
+		return nil, errs.Combine(err, upload.Abort())
+	}
+
+	err = upload.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return upload.Info(), nil
+}
+
+// downloadObject starts a download of the object, following references to
+// deduplicated content. The returned object describes the requested object,
+// while the download reads the referenced content.
+func (layer *gatewayLayer) downloadObject(ctx context.Context, project *uplink.Project, bucketName, objectPath string, opts *uplink.DownloadOptions) (_ *uplink.Download, _ *uplink.Object, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if layer.gateway.config.DedupBucket == "" {
+		download, err := project.DownloadObject(ctx, bucketName, objectPath, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return download, download.Info(), nil
+	}
+
+	object, err := project.StatObject(ctx, bucketName, objectPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash, ok := object.Custom[dedupKey]
+	if !ok {
+		download, err := project.DownloadObject(ctx, bucketName, objectPath, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return download, download.Info(), nil
+	}
+
+	download, err := project.DownloadObject(ctx, layer.gateway.config.DedupBucket, hash, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return download, object, nil
+}
+
+// objectSize returns the size of the object content, which is the size of the
+// referenced content for deduplicated objects.
+func objectSize(object *uplink.Object) int64 {
+	if size, ok := object.Custom[dedupSizeKey]; ok {
+		if size, err := strconv.ParseInt(size, 10, 64); err == nil {
+			return size
+		}
+	}
+	return object.System.ContentLength
+}
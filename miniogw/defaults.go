@@ -0,0 +1,34 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"strings"
+)
+
+// applyMetadataDefaults returns the metadata of an object uploaded to the
+// bucket with the default metadata of the bucket added for the keys the
+// client didn't set. Keys are compared case-insensitively, like the headers
+// they are uploaded with.
+func (gateway *Gateway) applyMetadataDefaults(bucketName string, metadata map[string]string) map[string]string {
+	defaults := gateway.config.BucketMetadata[bucketName]
+	if len(defaults) == 0 {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = make(map[string]string, len(defaults))
+	}
+
+next:
+	for key, value := range defaults {
+		for set := range metadata {
+			if strings.EqualFold(set, key) {
+				continue next
+			}
+		}
+		metadata[key] = value
+		mon.Counter("metadata_default_applied").Inc(1)
+	}
+	return metadata
+}
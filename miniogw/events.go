@@ -0,0 +1,98 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"net/http"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/event"
+)
+
+// layerEvents records the access log entries of object requests and sends
+// the notifications about created and deleted objects. It wraps all other
+// layers, so the events have the bucket names and object keys clients use,
+// not those of namespaces, aliases or rewrites.
+type layerEvents struct {
+	minio.ObjectLayer
+	gateway *Gateway
+}
+
+func (events *layerEvents) DeleteObject(ctx context.Context, bucket, object string) (err error) {
+	defer func() { events.gateway.logAccess(ctx, "REST.DELETE.OBJECT", bucket, object, -1, err) }()
+	err = events.ObjectLayer.DeleteObject(ctx, bucket, object)
+	if err == nil {
+		events.notify(event.ObjectRemovedDelete, bucket, object, minio.ObjectInfo{})
+	}
+	return err
+}
+
+func (events *layerEvents) DeleteObjects(ctx context.Context, bucket string, objects []string) (errors []error, err error) {
+	errors, err = events.ObjectLayer.DeleteObjects(ctx, bucket, objects)
+	for i, object := range objects {
+		deleteErr := err
+		if i < len(errors) {
+			deleteErr = errors[i]
+		}
+		events.gateway.logAccess(ctx, "REST.DELETE.OBJECT", bucket, object, -1, deleteErr)
+		if deleteErr == nil {
+			events.notify(event.ObjectRemovedDelete, bucket, object, minio.ObjectInfo{})
+		}
+	}
+	return errors, err
+}
+
+func (events *layerEvents) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
+	defer func() { events.gateway.logAccess(ctx, "REST.GET.OBJECT", bucket, object, -1, err) }()
+	return events.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rs, h, lockType, opts)
+}
+
+func (events *layerEvents) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	defer func() { events.gateway.logAccess(ctx, "REST.HEAD.OBJECT", bucket, object, objInfo.Size, err) }()
+	return events.ObjectLayer.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+func (events *layerEvents) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
+	defer func() { events.gateway.logAccess(ctx, "REST.GET.BUCKET", bucket, "", -1, err) }()
+	return events.ObjectLayer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+}
+
+func (events *layerEvents) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
+	defer func() { events.gateway.logAccess(ctx, "REST.GET.BUCKET", bucket, "", -1, err) }()
+	return events.ObjectLayer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+}
+
+func (events *layerEvents) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	defer func() { events.gateway.logAccess(ctx, "REST.COPY.OBJECT", destBucket, destObject, objInfo.Size, err) }()
+	objInfo, err = events.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+	if err == nil {
+		events.notify(event.ObjectCreatedCopy, destBucket, destObject, objInfo)
+	}
+	return objInfo, err
+}
+
+func (events *layerEvents) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	defer func() { events.gateway.logAccess(ctx, "REST.PUT.OBJECT", bucket, object, objInfo.Size, err) }()
+	objInfo, err = events.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+	if err == nil {
+		events.notify(event.ObjectCreatedPut, bucket, object, objInfo)
+	}
+	return objInfo, err
+}
+
+func (events *layerEvents) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	objInfo, err = events.ObjectLayer.CompleteMultipartUpload(ctx, bucket, object, uploadID, uploadedParts, opts)
+	if err == nil {
+		events.notify(event.ObjectCreatedCompleteMultipartUpload, bucket, object, objInfo)
+	}
+	return objInfo, err
+}
+
+// notify sends the notification about the event on object with the names the
+// client used instead of those in objInfo.
+func (events *layerEvents) notify(name event.Name, bucket, object string, objInfo minio.ObjectInfo) {
+	objInfo.Bucket, objInfo.Name = bucket, object
+	events.gateway.notify(name, objInfo)
+}
@@ -0,0 +1,38 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"fmt"
+	"net/http"
+
+	minio "github.com/minio/minio/cmd"
+
+	"storj.io/uplink"
+)
+
+// expirationHeader is the response header telling clients when and by which
+// rule the object expires.
+const expirationHeader = "X-Amz-Expiration"
+
+// withExpiration returns the info of the object with the expiration header
+// added if the object expires and the header is enabled. minio writes the
+// metadata of the info as response headers.
+func (gateway *Gateway) withExpiration(info minio.ObjectInfo, object *uplink.Object) minio.ObjectInfo {
+	ruleID := gateway.config.ExpirationRuleID
+	if ruleID == "" || object.System.Expires.IsZero() {
+		return info
+	}
+
+	// the metadata is shared with the object, so it's copied before adding to
+	// it
+	metadata := make(map[string]string, len(info.UserDefined)+1)
+	for k, v := range info.UserDefined {
+		metadata[k] = v
+	}
+	metadata[expirationHeader] = fmt.Sprintf(`expiry-date="%s", rule-id="%s"`,
+		object.System.Expires.UTC().Format(http.TimeFormat), ruleID)
+	info.UserDefined = metadata
+	return info
+}
@@ -0,0 +1,261 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+// ConcurrencyConfig configures how many object requests are served
+// concurrently and how the slots are shared between buckets.
+type ConcurrencyConfig struct {
+	Limit  int  `help:"maximum number of concurrent object requests, shared fairly between buckets, unlimited if zero" default:"0"`
+	Strict bool `help:"limit the requests of each busy bucket to an equal share of the limit, even if it leaves slots unused, instead of only serving the bucket with the fewest requests first" default:"false"`
+}
+
+// fairScheduler shares a limited number of request slots between buckets.
+//
+// A free slot is given to the waiting request of the bucket with the fewest
+// requests in progress, the longest waiting one on ties, so a busy bucket
+// can't starve the other ones. In strict mode, each bucket with requests in
+// progress or waiting additionally holds at most an equal share of the slots.
+type fairScheduler struct {
+	limit  int
+	strict bool
+
+	mu      sync.Mutex
+	total   int
+	next    uint64
+	active  map[string]int
+	waiting map[string][]*fairWaiter
+}
+
+// fairWaiter is a request waiting for a slot.
+type fairWaiter struct {
+	seq   uint64
+	ready chan struct{}
+}
+
+// newFairScheduler returns a scheduler of limit slots.
+func newFairScheduler(config ConcurrencyConfig) *fairScheduler {
+	return &fairScheduler{
+		limit:   config.Limit,
+		strict:  config.Strict,
+		active:  make(map[string]int),
+		waiting: make(map[string][]*fairWaiter),
+	}
+}
+
+// acquire waits for a slot for a request to bucket. The returned function
+// has to be called to release the slot once the request is done.
+func (scheduler *fairScheduler) acquire(ctx context.Context, bucket string) (release func(), err error) {
+	waiter := &fairWaiter{ready: make(chan struct{})}
+
+	scheduler.mu.Lock()
+	waiter.seq = scheduler.next
+	scheduler.next++
+	scheduler.waiting[bucket] = append(scheduler.waiting[bucket], waiter)
+	scheduler.dispatch()
+	scheduler.mu.Unlock()
+
+	release = func() { scheduler.release(bucket) }
+
+	select {
+	case <-waiter.ready:
+		return release, nil
+	default:
+		mon.Counter("concurrency_wait").Inc(1)
+	}
+
+	select {
+	case <-waiter.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	scheduler.mu.Lock()
+	waiters := scheduler.waiting[bucket]
+	for i := range waiters {
+		if waiters[i] == waiter {
+			scheduler.remove(bucket, i)
+			scheduler.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	scheduler.mu.Unlock()
+
+	// the slot was given to the request while it was canceled
+	release()
+	return nil, ctx.Err()
+}
+
+// release releases a slot of a request to bucket.
+func (scheduler *fairScheduler) release(bucket string) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	scheduler.total--
+	scheduler.active[bucket]--
+	if scheduler.active[bucket] <= 0 {
+		delete(scheduler.active, bucket)
+	}
+	scheduler.dispatch()
+}
+
+// dispatch gives the free slots to waiting requests. It has to be called with
+// the lock held.
+func (scheduler *fairScheduler) dispatch() {
+	for scheduler.total < scheduler.limit {
+		share := scheduler.share()
+
+		next, found := "", false
+		for bucket, waiters := range scheduler.waiting {
+			if scheduler.strict && scheduler.active[bucket] >= share {
+				continue
+			}
+			if !found || scheduler.active[bucket] < scheduler.active[next] ||
+				scheduler.active[bucket] == scheduler.active[next] && waiters[0].seq < scheduler.waiting[next][0].seq {
+				next, found = bucket, true
+			}
+		}
+		if !found {
+			return
+		}
+
+		waiter := scheduler.waiting[next][0]
+		scheduler.remove(next, 0)
+		scheduler.total++
+		scheduler.active[next]++
+		close(waiter.ready)
+	}
+}
+
+// share returns the equal share of the slots of each bucket with requests in
+// progress or waiting. It has to be called with the lock held.
+func (scheduler *fairScheduler) share() int {
+	buckets := len(scheduler.active)
+	for bucket := range scheduler.waiting {
+		if _, ok := scheduler.active[bucket]; !ok {
+			buckets++
+		}
+	}
+	if buckets == 0 {
+		return scheduler.limit
+	}
+	return (scheduler.limit + buckets - 1) / buckets
+}
+
+// remove removes the i-th waiting request of bucket. It has to be called with
+// the lock held.
+func (scheduler *fairScheduler) remove(bucket string, i int) {
+	waiters := scheduler.waiting[bucket]
+	waiters = append(waiters[:i], waiters[i+1:]...)
+	if len(waiters) == 0 {
+		delete(scheduler.waiting, bucket)
+		return
+	}
+	scheduler.waiting[bucket] = waiters
+}
+
+// layerFair limits the concurrent object requests of the wrapped layer with
+// a scheduler shared between buckets.
+//
+// Parts of multipart uploads aren't limited, as they are streamed in order
+// into a single upload and a part holding a slot could wait for a previous
+// part waiting for a slot.
+type layerFair struct {
+	minio.ObjectLayer
+	scheduler *fairScheduler
+}
+
+func (fair *layerFair) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
+	release, err := fair.scheduler.acquire(ctx, bucket)
+	if err != nil {
+		return minio.ListObjectsInfo{}, err
+	}
+	defer release()
+	return fair.ObjectLayer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+}
+
+func (fair *layerFair) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
+	release, err := fair.scheduler.acquire(ctx, bucket)
+	if err != nil {
+		return minio.ListObjectsV2Info{}, err
+	}
+	defer release()
+	return fair.ObjectLayer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+}
+
+func (fair *layerFair) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
+	release, err := fair.scheduler.acquire(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err = fair.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rs, h, lockType, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// the slot is held until the content is read
+	closer := func() {
+		_ = reader.Close()
+		release()
+	}
+	return minio.NewGetObjectReaderFromReader(reader, reader.ObjInfo, minio.ObjectOptions{}, closer)
+}
+
+func (fair *layerFair) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
+	release, err := fair.scheduler.acquire(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fair.ObjectLayer.GetObject(ctx, bucket, object, startOffset, length, writer, etag, opts)
+}
+
+func (fair *layerFair) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	release, err := fair.scheduler.acquire(ctx, bucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return fair.ObjectLayer.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+func (fair *layerFair) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	release, err := fair.scheduler.acquire(ctx, bucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return fair.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+// CopyObject isn't limited, as minio copies while it holds the reader of the
+// source, which holds a slot of the source bucket already.
+
+func (fair *layerFair) DeleteObject(ctx context.Context, bucket, object string) error {
+	release, err := fair.scheduler.acquire(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fair.ObjectLayer.DeleteObject(ctx, bucket, object)
+}
+
+func (fair *layerFair) DeleteObjects(ctx context.Context, bucket string, objects []string) ([]error, error) {
+	release, err := fair.scheduler.acquire(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return fair.ObjectLayer.DeleteObjects(ctx, bucket, objects)
+}
@@ -11,11 +11,15 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	miniov6 "github.com/minio/minio-go/v6"
 	minio "github.com/minio/minio/cmd"
+	xhttp "github.com/minio/minio/cmd/http"
 	"github.com/minio/minio/pkg/auth"
 	bucketsse "github.com/minio/minio/pkg/bucket/encryption"
+	"github.com/minio/minio/pkg/bucket/object/tagging"
 	"github.com/minio/minio/pkg/bucket/policy"
 	"github.com/minio/minio/pkg/hash"
 	"github.com/spacemonkeygo/monkit/v3"
@@ -33,20 +37,55 @@ var (
 	Error = errs.Class("Storj Gateway error")
 )
 
+// emptyETag is the ETag of objects without content, the MD5 of no bytes.
+const emptyETag = "d41d8cd98f00b204e9800998ecf8427e"
+
 // NewStorjGateway creates a new Storj S3 gateway.
-func NewStorjGateway(access *uplink.Access, config uplink.Config, website bool) *Gateway {
-	return &Gateway{
-		access:  access,
-		config:  config,
-		website: website,
+func NewStorjGateway(access *uplink.Access, uplinkConfig uplink.Config, config Config) *Gateway {
+	registerRequestHandler()
+
+	gateway := &Gateway{
+		access:       access,
+		uplinkConfig: uplinkConfig,
+		config:       config,
+		closed:       make(chan struct{}),
+	}
+	if config.Webhook.Endpoint != "" {
+		gateway.notifier = newNotifier(config.Webhook)
+	}
+	if config.AccessLogInterval > 0 {
+		go gateway.flushAccessLogsEvery(config.AccessLogInterval)
+	}
+	if config.Usage.enabled() {
+		gateway.usage.start = time.Now().UTC()
+		if config.Usage.Interval > 0 {
+			go gateway.exportUsageEvery(config.Usage.Interval)
+		}
 	}
+	if config.Concurrency.Limit > 0 {
+		gateway.scheduler = newFairScheduler(config.Concurrency)
+	}
+
+	gateway.probeWrites(context.Background())
+
+	return gateway
 }
 
 // Gateway is the implementation of a minio cmd.Gateway
 type Gateway struct {
-	access  *uplink.Access
-	config  uplink.Config
-	website bool
+	access       *uplink.Access
+	uplinkConfig uplink.Config
+	config       Config
+	notifier     *notifier
+	accessLogs   accessLogs
+	usage        usageAccounts
+	closed       chan struct{}
+	closeOnce    sync.Once
+	readOnly     int32
+	scheduler    *fairScheduler
+
+	mu     sync.Mutex
+	layers []*gatewayLayer
 }
 
 // Name implements cmd.Gateway
@@ -58,16 +97,102 @@ func (gateway *Gateway) Name() string {
 func (gateway *Gateway) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
 	ctx := minio.GlobalContext
 
-	project, err := gateway.config.OpenProject(ctx, gateway.access)
+	if err := validateKeyRewrites(gateway.config.KeyRewrites); err != nil {
+		return nil, err
+	}
+
+	project, err := gateway.uplinkConfig.OpenProject(ctx, gateway.access)
 	if err != nil {
 		return nil, Error.Wrap(err)
 	}
 
-	return &gatewayLayer{
-		gateway:   gateway,
-		project:   project,
-		multipart: NewMultipartUploads(),
-	}, nil
+	mirror, err := gateway.openMirror(ctx)
+	if err != nil {
+		return nil, Error.Wrap(errs.Combine(err, project.Close()))
+	}
+
+	layer := &gatewayLayer{
+		gateway:      gateway,
+		project:      project,
+		projectUsers: new(sync.WaitGroup),
+		mirror:       mirror,
+		multipart:    NewMultipartUploads(),
+	}
+	if gateway.config.SlowStart.Duration > 0 {
+		layer.slowStart = newSlowStart(gateway.config.SlowStart)
+	}
+
+	replica, err := gateway.newReplicaLayer(ctx)
+	if err != nil {
+		return nil, Error.Wrap(errs.Combine(err, layer.Shutdown(ctx)))
+	}
+
+	gateway.mu.Lock()
+	gateway.layers = append(gateway.layers, layer)
+	gateway.mu.Unlock()
+
+	var objectLayer minio.ObjectLayer = layer
+	if replica != nil {
+		objectLayer = &layerReplica{
+			ObjectLayer: layer,
+			replica:     replica,
+			preferred:   gateway.config.Replica.Preferred,
+		}
+	}
+
+	if layer.slowStart != nil {
+		objectLayer = &layerSlowStart{ObjectLayer: objectLayer, slow: layer.slowStart}
+	}
+
+	objectLayer = &layerReadOnly{ObjectLayer: objectLayer, gateway: gateway}
+
+	if gateway.scheduler != nil {
+		objectLayer = &layerFair{ObjectLayer: objectLayer, scheduler: gateway.scheduler}
+	}
+
+	if len(gateway.config.BucketAliases) > 0 {
+		objectLayer = gateway.mapKeys(objectLayer, &bucketAliases{aliases: gateway.config.BucketAliases})
+	}
+
+	if gateway.config.DenyReservedKeys {
+		objectLayer = gateway.mapKeys(objectLayer, &reservedKeys{
+			prefixes: gateway.reservedPrefixes(),
+			dedup:    gateway.config.DedupBucket,
+		})
+	}
+
+	if gateway.config.Usage.enabled() {
+		objectLayer = &layerUsage{ObjectLayer: objectLayer, accounts: &gateway.usage}
+	}
+
+	switch gateway.config.ControlKeys {
+	case ControlKeysReject:
+		objectLayer = gateway.mapKeys(objectLayer, &controlKeys{})
+	case ControlKeysEncode:
+		objectLayer = gateway.mapKeys(objectLayer, &controlKeys{encode: true})
+	}
+
+	if gateway.config.IsolateAccessKeys {
+		objectLayer = gateway.mapKeys(objectLayer, keyNamespace{})
+	}
+
+	// the keys are rewritten as clients use them, before they are confined
+	// to the namespace
+	if len(gateway.config.KeyRewrites) > 0 {
+		rewrites := &keyRewrites{rewrites: gateway.config.KeyRewrites}
+		objectLayer = &layerRewrite{layerKeys: gateway.mapKeys(objectLayer, rewrites), rewrites: rewrites}
+	}
+
+	// key mapping layers answer deletes without objects themselves, so empty
+	// requests are rejected outside of them
+	if gateway.config.StrictDeleteObjects {
+		objectLayer = &layerStrictDelete{ObjectLayer: objectLayer}
+	}
+
+	// the events are recorded with the names clients use, outside of all
+	// layers mapping them
+	objectLayer = &layerEvents{ObjectLayer: objectLayer, gateway: gateway}
+	return objectLayer, nil
 }
 
 // Production implements cmd.Gateway
@@ -75,21 +200,86 @@ func (gateway *Gateway) Production() bool {
 	return version.Build.Release
 }
 
+// Close stops the background work of the gateway, flushes the collected
+// access logs and exports the used bandwidth. It's also called when minio
+// shuts down the gateway layer, and may be called more than once.
+func (gateway *Gateway) Close() error {
+	gateway.closeOnce.Do(func() {
+		close(gateway.closed)
+		if gateway.notifier != nil {
+			gateway.notifier.close()
+		}
+	})
+	return errs.Combine(
+		gateway.FlushAccessLogs(context.Background()),
+		gateway.ExportUsage(context.Background()),
+	)
+}
+
 type gatewayLayer struct {
 	minio.GatewayUnsupported
 	gateway   *Gateway
-	project   *uplink.Project
 	multipart *MultipartUploads
+
+	projectMu sync.Mutex
+	project   *uplink.Project
+	// projectUsers are the requests using the project.
+	projectUsers *sync.WaitGroup
+	// reopened is closed when the project being reopened is ready,
+	// it's nil when no reopen is in progress.
+	reopened chan struct{}
+
+	// mirror is the project uploads are mirrored to, it's nil if mirroring
+	// is disabled.
+	mirror *uplink.Project
+
+	// buckets caches whether buckets exist, if enabled.
+	buckets bucketCache
+
+	// slowStart ramps up the concurrent requests after the project is
+	// (re)opened, it's nil if disabled.
+	slowStart *slowStart
 }
 
 func (layer *gatewayLayer) DeleteBucket(ctx context.Context, bucketName string, forceDelete bool) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	if forceDelete {
 		return errors.New("force delete is not supported")
 	}
 
-	_, err = layer.project.DeleteBucket(ctx, bucketName)
+	// Pending multipart uploads aren't visible to the satellite yet, but
+	// would be stored into the deleted bucket when they are completed.
+	pending := layer.multipart.PendingIn(bucketName)
+	if len(pending) > 0 && !layer.gateway.config.AbortUploadsOnBucketDelete {
+		return minio.BucketNotEmpty{Bucket: bucketName}
+	}
+	if len(pending) > 0 {
+		// The uploads are only aborted if the bucket can be deleted
+		// afterwards, so check that it has no committed objects first.
+		objects := project.ListObjects(ctx, bucketName, &uplink.ListObjectsOptions{Recursive: true})
+		if objects.Next() {
+			return minio.BucketNotEmpty{Bucket: bucketName}
+		}
+		if err := objects.Err(); err != nil {
+			return convertError(err, bucketName, "")
+		}
+	}
+	for _, upload := range pending {
+		err = layer.AbortMultipartUpload(ctx, bucketName, upload.Object, upload.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = project.DeleteBucket(ctx, bucketName)
+	layer.buckets.invalidate(bucketName)
 
 	return convertError(err, bucketName, "")
 }
@@ -97,13 +287,28 @@ func (layer *gatewayLayer) DeleteBucket(ctx context.Context, bucketName string,
 func (layer *gatewayLayer) DeleteObject(ctx context.Context, bucketName, objectPath string) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// TODO this should be removed and implemented on satellite side
-	_, err = layer.project.StatBucket(ctx, bucketName)
+	err = layer.statBucket(ctx, project, bucketName)
 	if err != nil {
 		return convertError(err, bucketName, objectPath)
 	}
 
-	_, err = layer.project.DeleteObject(ctx, bucketName, objectPath)
+	if layer.gateway.indexing() {
+		previous := layer.indexedMetadata(ctx, project, bucketName, objectPath)
+		defer func() {
+			if err == nil {
+				layer.updateIndex(ctx, project, bucketName, objectPath, previous, nil)
+			}
+		}()
+	}
+
+	_, err = project.DeleteObject(ctx, bucketName, objectPath)
 
 	return convertError(err, bucketName, objectPath)
 }
@@ -124,7 +329,13 @@ func (layer *gatewayLayer) DeleteObjects(ctx context.Context, bucketName string,
 func (layer *gatewayLayer) GetBucketInfo(ctx context.Context, bucketName string) (bucketInfo minio.BucketInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	bucket, err := layer.project.StatBucket(ctx, bucketName)
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return minio.BucketInfo{}, err
+	}
+	defer release()
+
+	bucket, err := project.StatBucket(ctx, bucketName)
 
 	if err != nil {
 		return minio.BucketInfo{}, convertError(err, bucketName, "")
@@ -139,12 +350,71 @@ func (layer *gatewayLayer) GetBucketInfo(ctx context.Context, bucketName string)
 func (layer *gatewayLayer) GetObjectNInfo(ctx context.Context, bucketName, objectPath string, rangeSpec *minio.HTTPRangeSpec, header http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// the project is released when the returned reader is closed
+		if err != nil {
+			release()
+		}
+	}()
+
 	// TODO this should be removed and implemented on satellite side
-	_, err = layer.project.StatBucket(ctx, bucketName)
+	err = layer.statBucket(ctx, project, bucketName)
 	if err != nil {
 		return nil, convertError(err, bucketName, objectPath)
 	}
 
+	objectPath, err = layer.encryptionPath(header, bucketName, objectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	requested := objectPath
+	objectPath, err = layer.resolveAlias(ctx, project, bucketName, objectPath)
+	if err != nil {
+		return nil, convertError(err, bucketName, requested)
+	}
+
+	if opts.PartNumber > 0 {
+		object, err := project.StatObject(ctx, bucketName, objectPath)
+		if err != nil {
+			return nil, convertError(err, bucketName, requested)
+		}
+
+		// objects uploaded at once consist of a single part
+		parts := objectParts(object)
+		if len(parts) == 0 {
+			parts = []minio.ObjectPartInfo{{Number: 1, Size: objectSize(object)}}
+		}
+
+		// Minio derives the Content-Range of the response from rangeSpec
+		// after this returns, so it's rewritten relative to the whole object.
+		partSpec, err := partRange(parts, opts.PartNumber, rangeSpec)
+		if err != nil {
+			return nil, err
+		}
+		if rangeSpec != nil {
+			*rangeSpec = *partSpec
+		} else {
+			rangeSpec = partSpec
+		}
+	}
+
+	if rangeSpec != nil && header.Get("If-Range") != "" {
+		// the validator is checked before the download is started, so the
+		// object isn't downloaded twice when it doesn't match
+		object, err := project.StatObject(ctx, bucketName, objectPath)
+		if err != nil {
+			return nil, convertError(err, bucketName, requested)
+		}
+		if !ifRangeMatches(header.Get("If-Range"), object) {
+			rangeSpec = ignoreRange(ctx, rangeSpec)
+		}
+	}
+
 	startOffset := int64(0)
 	length := int64(-1)
 	if rangeSpec != nil {
@@ -153,13 +423,13 @@ func (layer *gatewayLayer) GetObjectNInfo(ctx context.Context, bucketName, objec
 				return nil, errs.New("Unexpected range specification case")
 			}
 			// TODO: can we avoid this additional call?
-			object, err := layer.project.StatObject(ctx, bucketName, objectPath)
+			object, err := project.StatObject(ctx, bucketName, objectPath)
 			if err != nil {
-				return nil, convertError(err, bucketName, objectPath)
+				return nil, convertError(err, bucketName, requested)
 			}
-			startOffset, length, err = rangeSpec.GetOffsetLength(object.System.ContentLength)
+			startOffset, length, err = rangeSpec.GetOffsetLength(objectSize(object))
 			if err != nil {
-				return nil, convertError(err, bucketName, objectPath)
+				return nil, convertError(err, bucketName, requested)
 			}
 		} else if rangeSpec.End < -1 {
 			return nil, errs.New("Unexpected range specification case")
@@ -171,57 +441,133 @@ func (layer *gatewayLayer) GetObjectNInfo(ctx context.Context, bucketName, objec
 		}
 	}
 
-	download, err := layer.project.DownloadObject(ctx, bucketName, objectPath, &uplink.DownloadOptions{
+	download, object, err := layer.downloadObject(ctx, project, bucketName, objectPath, &uplink.DownloadOptions{
 		Offset: startOffset,
 		Length: length,
 	})
 	if err != nil {
-		return nil, convertError(err, bucketName, objectPath)
+		return nil, convertError(err, bucketName, requested)
+	}
+
+	if !layer.websiteAccessAllowed(ctx, object) {
+		_ = download.Close()
+		return nil, minio.PrefixAccessDenied{Bucket: bucketName, Object: requested}
 	}
 
-	object := download.Info()
-	if startOffset < 0 || length < -1 || startOffset+length > object.System.ContentLength {
+	if rangeSpec != nil && !ifRangeMatches(header.Get("If-Range"), object) {
+		// the object was replaced after it was checked above
+		err = download.Close()
+		if err != nil {
+			return nil, convertError(err, bucketName, requested)
+		}
+
+		rangeSpec = ignoreRange(ctx, rangeSpec)
+		startOffset, length = 0, -1
+
+		download, object, err = layer.downloadObject(ctx, project, bucketName, objectPath, nil)
+		if err != nil {
+			return nil, convertError(err, bucketName, requested)
+		}
+	}
+
+	if startOffset < 0 || length < -1 || startOffset+length > objectSize(object) {
+		_ = download.Close()
 		return nil, minio.InvalidRange{
 			OffsetBegin:  startOffset,
 			OffsetEnd:    startOffset + length - 1,
-			ResourceSize: object.System.ContentLength,
+			ResourceSize: objectSize(object),
 		}
 	}
 
-	objectInfo := minioObjectInfo(bucketName, "", object)
-	downloadCloser := func() { _ = download.Close() }
+	content, err := layer.gateway.decode(ctx, bucketName, object, startOffset, download)
+	if err != nil {
+		_ = download.Close()
+		return nil, convertError(err, bucketName, requested)
+	}
+
+	content = validateSize(content, objectSize(object), startOffset, length)
 
-	return minio.NewGetObjectReaderFromReader(download, objectInfo, opts, downloadCloser)
+	objectInfo := layer.gateway.withExpiration(minioObjectInfo(bucketName, "", object), object)
+	objectInfo.Name = requested
+	downloadCloser := func() {
+		_ = download.Close()
+		release()
+	}
+
+	if config := layer.gateway.config.Readahead; config.enabled() {
+		readahead := NewReadaheadReader(ctx, content, config.Size)
+		content = readahead
+		downloadCloser = func() {
+			_ = readahead.Close()
+			_ = download.Close()
+			release()
+		}
+	}
+
+	return minio.NewGetObjectReaderFromReader(content, objectInfo, opts, downloadCloser)
 }
 
 func (layer *gatewayLayer) GetObject(ctx context.Context, bucketName, objectPath string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// TODO this should be removed and implemented on satellite side
-	_, err = layer.project.StatBucket(ctx, bucketName)
+	err = layer.statBucket(ctx, project, bucketName)
 	if err != nil {
 		return convertError(err, bucketName, objectPath)
 	}
 
-	download, err := layer.project.DownloadObject(ctx, bucketName, objectPath, &uplink.DownloadOptions{
+	objectPath, err = layer.encryptionPath(getRequest(ctx).header, bucketName, objectPath)
+	if err != nil {
+		return err
+	}
+
+	requested := objectPath
+	objectPath, err = layer.resolveAlias(ctx, project, bucketName, objectPath)
+	if err != nil {
+		return convertError(err, bucketName, requested)
+	}
+
+	download, object, err := layer.downloadObject(ctx, project, bucketName, objectPath, &uplink.DownloadOptions{
 		Offset: startOffset,
 		Length: length,
 	})
 	if err != nil {
-		return convertError(err, bucketName, objectPath)
+		return convertError(err, bucketName, requested)
 	}
 	defer func() { err = errs.Combine(err, download.Close()) }()
 
-	object := download.Info()
-	if startOffset < 0 || length < -1 || startOffset+length > object.System.ContentLength {
+	if !layer.websiteAccessAllowed(ctx, object) {
+		return minio.PrefixAccessDenied{Bucket: bucketName, Object: requested}
+	}
+
+	if startOffset < 0 || length < -1 || startOffset+length > objectSize(object) {
 		return minio.InvalidRange{
 			OffsetBegin:  startOffset,
 			OffsetEnd:    startOffset + length,
-			ResourceSize: object.System.ContentLength,
+			ResourceSize: objectSize(object),
 		}
 	}
 
-	_, err = io.Copy(writer, download)
+	content, err := layer.gateway.decode(ctx, bucketName, object, startOffset, download)
+	if err != nil {
+		return convertError(err, bucketName, requested)
+	}
+
+	content = validateSize(content, objectSize(object), startOffset, length)
+
+	if config := layer.gateway.config.Readahead; config.enabled() {
+		readahead := NewReadaheadReader(ctx, content, config.Size)
+		defer func() { _ = readahead.Close() }()
+		content = readahead
+	}
+
+	_, err = io.Copy(writer, content)
 
 	return err
 }
@@ -229,24 +575,81 @@ func (layer *gatewayLayer) GetObject(ctx context.Context, bucketName, objectPath
 func (layer *gatewayLayer) GetObjectInfo(ctx context.Context, bucketName, objectPath string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+
 	// TODO this should be removed and implemented on satellite side
-	_, err = layer.project.StatBucket(ctx, bucketName)
+	err = layer.statBucket(ctx, project, bucketName)
 	if err != nil {
 		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
 	}
 
-	object, err := layer.project.StatObject(ctx, bucketName, objectPath)
+	target, err := layer.encryptionPath(getRequest(ctx).header, bucketName, objectPath)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	target, err = layer.resolveAlias(ctx, project, bucketName, target)
 	if err != nil {
 		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
 	}
 
-	return minioObjectInfo(bucketName, "", object), nil
+	object, err := project.StatObject(ctx, bucketName, target)
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	if !layer.websiteAccessAllowed(ctx, object) {
+		return minio.ObjectInfo{}, minio.PrefixAccessDenied{Bucket: bucketName, Object: objectPath}
+	}
+	getRequest(ctx).publicRead = layer.publicRead(object)
+
+	objInfo = layer.gateway.withExpiration(minioObjectInfo(bucketName, "", object), object)
+	objInfo.Name = objectPath
+	return objInfo, nil
+}
+
+func (layer *gatewayLayer) GetObjectTag(ctx context.Context, bucketName, objectPath string) (tags tagging.Tagging, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return tagging.Tagging{}, err
+	}
+	defer release()
+
+	// TODO this should be removed and implemented on satellite side
+	err = layer.statBucket(ctx, project, bucketName)
+	if err != nil {
+		return tagging.Tagging{}, convertError(err, bucketName, objectPath)
+	}
+
+	objectPath, err = layer.encryptionPath(getRequest(ctx).header, bucketName, objectPath)
+	if err != nil {
+		return tagging.Tagging{}, err
+	}
+
+	object, err := project.StatObject(ctx, bucketName, objectPath)
+	if err != nil {
+		return tagging.Tagging{}, convertError(err, bucketName, objectPath)
+	}
+
+	return objectTags(object)
 }
 
 func (layer *gatewayLayer) ListBuckets(ctx context.Context) (items []minio.BucketInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	buckets := layer.project.ListBuckets(ctx, nil)
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	buckets := project.ListBuckets(ctx, nil)
 	for buckets.Next() {
 		info := buckets.Item()
 		items = append(items, minio.BucketInfo{
@@ -263,6 +666,12 @@ func (layer *gatewayLayer) ListBuckets(ctx context.Context) (items []minio.Bucke
 func (layer *gatewayLayer) ListObjects(ctx context.Context, bucketName, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
 	// TODO maybe this should be checked by project.ListObjects
 	if bucketName == "" {
 		return minio.ListObjectsInfo{}, minio.BucketNameInvalid{}
@@ -277,14 +686,16 @@ func (layer *gatewayLayer) ListObjects(ctx context.Context, bucketName, prefix,
 	}
 
 	// TODO this should be removed and implemented on satellite side
-	_, err = layer.project.StatBucket(ctx, bucketName)
+	err = layer.statBucket(ctx, project, bucketName)
 	if err != nil {
 		return result, convertError(err, bucketName, "")
 	}
 
-	list := layer.project.ListObjects(ctx, bucketName, &uplink.ListObjectsOptions{
+	// TODO: the page size of the iterator should be derived from maxKeys,
+	// but uplink doesn't allow to set it, so the satellite default is used.
+	list := project.ListObjects(ctx, bucketName, &uplink.ListObjectsOptions{
 		Prefix:    prefix,
-		Cursor:    marker,
+		Cursor:    listCursor(prefix, marker),
 		Recursive: delimiter == "",
 
 		System: true,
@@ -297,23 +708,32 @@ func (layer *gatewayLayer) ListObjects(ctx context.Context, bucketName, prefix,
 
 	limit := maxKeys
 	for (limit > 0 || maxKeys == 0) && list.Next() {
-		limit--
 		object := list.Item()
+		if !layer.listable(prefix, marker, objects, prefixes, object) {
+			continue
+		}
+		limit--
+
+		// the marker must move past prefixes too, otherwise a page made
+		// only of prefixes (e.g. keys consisting of delimiters like "///")
+		// would be returned again and again
+		startAfter = object.Key
+
 		if object.IsPrefix {
 			prefixes = append(prefixes, object.Key)
 			continue
 		}
 
 		objects = append(objects, minioObjectInfo(bucketName, "", object))
-
-		startAfter = object.Key
-
 	}
 	if list.Err() != nil {
 		return result, convertError(list.Err(), bucketName, "")
 	}
 
-	more := list.Next()
+	more := false
+	for !more && list.Next() {
+		more = layer.listable(prefix, marker, objects, prefixes, list.Item())
+	}
 	if list.Err() != nil {
 		return result, convertError(list.Err(), bucketName, "")
 	}
@@ -333,6 +753,12 @@ func (layer *gatewayLayer) ListObjects(ctx context.Context, bucketName, prefix,
 func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return minio.ListObjectsV2Info{ContinuationToken: continuationToken}, err
+	}
+	defer release()
+
 	if prefix != "" && !strings.HasSuffix(prefix, "/") {
 		return result, miniov6.ErrInvalidArgument("prefix should end with slash")
 	}
@@ -342,7 +768,7 @@ func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix
 	}
 
 	// TODO this should be removed and implemented on satellite side
-	_, err = layer.project.StatBucket(ctx, bucketName)
+	err = layer.statBucket(ctx, project, bucketName)
 	if err != nil {
 		return minio.ListObjectsV2Info{ContinuationToken: continuationToken}, convertError(err, bucketName, "")
 	}
@@ -360,9 +786,10 @@ func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix
 	var objects []minio.ObjectInfo
 	var prefixes []string
 
-	list := layer.project.ListObjects(ctx, bucketName, &uplink.ListObjectsOptions{
+	// TODO: derive the page size of the iterator from maxKeys, see ListObjects
+	list := project.ListObjects(ctx, bucketName, &uplink.ListObjectsOptions{
 		Prefix:    prefix,
-		Cursor:    startAfterPath,
+		Cursor:    listCursor(prefix, startAfterPath),
 		Recursive: recursive,
 
 		System: true,
@@ -371,22 +798,29 @@ func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix
 
 	limit := maxKeys
 	for (limit > 0 || maxKeys == 0) && list.Next() {
-		limit--
 		object := list.Item()
+		if !layer.listable(prefix, startAfterPath, objects, prefixes, object) {
+			continue
+		}
+		limit--
+
+		startAfter = object.Key
+
 		if object.IsPrefix {
 			prefixes = append(prefixes, object.Key)
 			continue
 		}
 
 		objects = append(objects, minioObjectInfo(bucketName, "", object))
-
-		startAfter = object.Key
 	}
 	if list.Err() != nil {
 		return result, convertError(list.Err(), bucketName, "")
 	}
 
-	more := list.Next()
+	more := false
+	for !more && list.Next() {
+		more = layer.listable(prefix, startAfterPath, objects, prefixes, list.Item())
+	}
 	if list.Err() != nil {
 		return result, convertError(list.Err(), bucketName, "")
 	}
@@ -404,12 +838,79 @@ func (layer *gatewayLayer) ListObjectsV2(ctx context.Context, bucketName, prefix
 	return result, nil
 }
 
+// listCursor converts a listing marker into a cursor for uplink, which is
+// relative to the listed prefix. Markers that are not full keys under the
+// prefix are passed as they are.
+func listCursor(prefix, marker string) string {
+	return strings.TrimPrefix(marker, prefix)
+}
+
+// listedAfter reports whether key should be listed after a full key marker.
+// A cursor cannot point past an object whose key equals the prefix (e.g. "/"
+// listed with prefix "/"), so such objects are skipped here.
+func listedAfter(prefix, marker, key string) bool {
+	if marker == "" || !strings.HasPrefix(marker, prefix) {
+		return true
+	}
+	return key > marker
+}
+
+// listable reports whether the object is listed on the page after the marker
+// and the objects and prefixes listed so far. The listing is truncated only
+// if there are more listable objects, so a listing of exactly maxKeys objects
+// isn't followed by an empty page.
+func (layer *gatewayLayer) listable(prefix, marker string, objects []minio.ObjectInfo, prefixes []string, object *uplink.Object) bool {
+	if !listedAfter(prefix, marker, object.Key) || isListedPrefix(prefix, object) || layer.gateway.isIndexKey(object.Key) || isReplaceKey(object.Key) || isVerifyKey(object.Key) {
+		return false
+	}
+	return listedInOrder(objects, prefixes, object.Key)
+}
+
+// listedInOrder reports whether key sorts after all keys and prefixes listed
+// so far on the page.
+//
+// Listings are consistent to the extent the iterator of uplink allows: every
+// key is returned at most once across the pages of a listing, as pages are
+// strictly ordered and continue after the last listed key, and keys present
+// throughout the listing are returned. Keys added or removed during the
+// listing may or may not be returned. The iterator itself continues from the
+// last key of its previous page, but a key it returns again or out of order
+// while the bucket is changed is skipped here as well.
+func listedInOrder(objects []minio.ObjectInfo, prefixes []string, key string) bool {
+	last := ""
+	if len(objects) > 0 {
+		last = objects[len(objects)-1].Name
+	}
+	if len(prefixes) > 0 && prefixes[len(prefixes)-1] > last {
+		last = prefixes[len(prefixes)-1]
+	}
+	if last != "" && key <= last {
+		mon.Counter("list_unordered_key_skipped").Inc(1)
+		return false
+	}
+	return true
+}
+
+// isListedPrefix reports whether object is a phantom common prefix equal to
+// the listed prefix itself. A key equal to the listed prefix (e.g. "a/" listed
+// with prefix "a/") is an object and must not be rolled up into a prefix.
+func isListedPrefix(prefix string, object *uplink.Object) bool {
+	return object.IsPrefix && object.Key == prefix
+}
+
 func (layer *gatewayLayer) MakeBucketWithLocation(ctx context.Context, bucketName string, location string) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// TODO: maybe this should return an error since we don't support locations
 
-	_, err = layer.project.CreateBucket(ctx, bucketName)
+	_, err = project.CreateBucket(ctx, bucketName)
+	layer.buckets.invalidate(bucketName)
 
 	return convertError(err, bucketName, "")
 }
@@ -417,6 +918,12 @@ func (layer *gatewayLayer) MakeBucketWithLocation(ctx context.Context, bucketNam
 func (layer *gatewayLayer) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+
 	if srcObject == "" {
 		return minio.ObjectInfo{}, minio.ObjectNameInvalid{Bucket: srcBucket}
 	}
@@ -424,28 +931,45 @@ func (layer *gatewayLayer) CopyObject(ctx context.Context, srcBucket, srcObject,
 		return minio.ObjectInfo{}, minio.ObjectNameInvalid{Bucket: destBucket}
 	}
 
+	// the encryption path selects the source to read, the destination is
+	// written as usual
+	srcObject, err = layer.encryptionPath(getRequest(ctx).header, srcBucket, srcObject)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
 	// TODO this should be removed and implemented on satellite side
-	_, err = layer.project.StatBucket(ctx, srcBucket)
+	err = layer.statBucket(ctx, project, srcBucket)
 	if err != nil {
 		return minio.ObjectInfo{}, convertError(err, srcBucket, "")
 	}
 
 	// TODO this should be removed and implemented on satellite side
 	if srcBucket != destBucket {
-		_, err = layer.project.StatBucket(ctx, destBucket)
+		err = layer.statBucket(ctx, project, destBucket)
 		if err != nil {
 			return minio.ObjectInfo{}, convertError(err, destBucket, "")
 		}
 	}
 
+	if layer.gateway.indexing() {
+		previous := layer.indexedMetadata(ctx, project, destBucket, destObject)
+		defer func() {
+			if err == nil {
+				layer.updateIndex(ctx, project, destBucket, destObject, previous, objInfo.UserDefined)
+			}
+		}()
+	}
+
 	if srcBucket == destBucket && srcObject == destObject {
-		// Source and destination are the same. Do nothing, otherwise copying
-		// the same object over itself may destroy it, especially if it is a
-		// larger one.
-		return srcInfo, nil
+		// Source and destination are the same, which minio allows only for
+		// metadata-only copies. Copying the object over itself would delete
+		// it before it's read, so only the metadata is replaced, keeping the
+		// ETag of the unchanged content, as S3 does.
+		return layer.replaceMetadata(ctx, project, srcBucket, srcObject, srcInfo)
 	}
 
-	download, err := layer.project.DownloadObject(ctx, srcBucket, srcObject, nil)
+	download, object, err := layer.downloadObject(ctx, project, srcBucket, srcObject, nil)
 	if err != nil {
 		return minio.ObjectInfo{}, convertError(err, srcBucket, srcObject)
 	}
@@ -454,27 +978,103 @@ func (layer *gatewayLayer) CopyObject(ctx context.Context, srcBucket, srcObject,
 		err = errs.Combine(err, download.Close())
 	}()
 
-	upload, err := layer.project.UploadObject(ctx, destBucket, destObject, nil)
+	// srcInfo.UserDefined holds either the metadata of the source or the
+	// replacing metadata, depending on the metadata directive of the request
+	metadata := make(map[string]string, len(srcInfo.UserDefined))
+	for k, v := range srcInfo.UserDefined {
+		metadata[k] = v
+	}
+	if srcInfo.UserDefined == nil {
+		for k, v := range object.Custom {
+			metadata[k] = v
+		}
+	}
+	// the expiration of the source is reported, not stored, and the content
+	// keys are set for the copied content below, which is a single part
+	delete(metadata, expirationHeader)
+	delete(metadata, partsKey)
+	delete(metadata, dedupKey)
+	delete(metadata, dedupSizeKey)
+	delete(metadata, transformedKey)
+
+	if _, ok := object.Custom[dedupKey]; ok {
+		// the content is deduplicated already, so only the reference to it
+		// is copied
+		return layer.copyDeduplicatedObject(ctx, project, object, destBucket, destObject, metadata)
+	}
+
+	upload, err := project.UploadObject(ctx, destBucket, destObject, nil)
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, destBucket, destObject)
+	}
+
+	mirror, err := layer.startMirror(ctx, destBucket, destObject)
 	if err != nil {
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
 		return minio.ObjectInfo{}, convertError(err, destBucket, destObject)
 	}
 
-	info := download.Info()
-	err = upload.SetCustomMetadata(ctx, info.Custom)
+	size := objectSize(object)
+
+	// the content of zero-byte objects isn't downloaded, as there is nothing
+	// to copy, which still commits an empty destination with the empty ETag
+	var source io.Reader = download
+	if size == 0 {
+		source = bytes.NewReader(nil)
+	}
+
+	// transforms may depend on the key, so the content is decoded as it's
+	// read and encoded again for the destination
+	source, err = layer.gateway.decode(ctx, srcBucket, object, 0, source)
 	if err != nil {
+		mirror.abort()
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
+		return minio.ObjectInfo{}, convertError(err, srcBucket, srcObject)
+	}
+
+	reader, err := hash.NewReader(source, size, "", "", size, true)
+	if err != nil {
+		mirror.abort()
 		abortErr := upload.Abort()
 		err = errs.Combine(err, abortErr)
 		return minio.ObjectInfo{}, convertError(err, destBucket, destObject)
 	}
 
-	reader, err := hash.NewReader(download, info.System.ContentLength, "", "", info.System.ContentLength, true)
+	content, err := layer.gateway.encode(ctx, destBucket, destObject, reader, metadata)
 	if err != nil {
+		mirror.abort()
 		abortErr := upload.Abort()
 		err = errs.Combine(err, abortErr)
 		return minio.ObjectInfo{}, convertError(err, destBucket, destObject)
 	}
 
-	_, err = io.Copy(upload, reader)
+	_, err = io.Copy(mirror.writer(upload), content)
+	if err != nil {
+		mirror.abort()
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
+		return minio.ObjectInfo{}, convertError(err, destBucket, destObject)
+	}
+
+	etag := hex.EncodeToString(reader.MD5Current())
+	if _, ok := object.Custom["s3:etag"]; ok {
+		// replacing metadata doesn't carry the ETag of the source
+		metadata["s3:etag"] = etag
+	}
+
+	err = upload.SetCustomMetadata(ctx, metadata)
+	if err != nil {
+		mirror.abort()
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
+		return minio.ObjectInfo{}, convertError(err, destBucket, destObject)
+	}
+
+	// the mirror is committed first, so a required mirror can still fail
+	// the copy
+	err = mirror.commit(ctx, metadata)
 	if err != nil {
 		abortErr := upload.Abort()
 		err = errs.Combine(err, abortErr)
@@ -483,21 +1083,42 @@ func (layer *gatewayLayer) CopyObject(ctx context.Context, srcBucket, srcObject,
 
 	err = upload.Commit()
 	if err != nil {
+		mirror.remove(ctx)
 		return minio.ObjectInfo{}, convertError(err, destBucket, destObject)
 	}
 
-	return minioObjectInfo(destBucket, hex.EncodeToString(reader.MD5Current()), upload.Info()), nil
+	return minioObjectInfo(destBucket, etag, upload.Info()), nil
 }
 
 func (layer *gatewayLayer) PutObject(ctx context.Context, bucketName, objectPath string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+
+	// Everything that may reject the upload has to be checked before the
+	// first read from data. Reading the body makes the HTTP server send
+	// "100 Continue" to clients that sent "Expect: 100-continue", after which
+	// they start uploading a body that would be thrown away.
+
 	// TODO this should be removed and implemented on satellite side
-	_, err = layer.project.StatBucket(ctx, bucketName)
+	err = layer.statBucket(ctx, project, bucketName)
 	if err != nil {
 		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
 	}
 
+	if layer.gateway.indexing() {
+		previous := layer.indexedMetadata(ctx, project, bucketName, objectPath)
+		defer func() {
+			if err == nil {
+				layer.updateIndex(ctx, project, bucketName, objectPath, previous, objInfo.UserDefined)
+			}
+		}()
+	}
+
 	if data == nil {
 		hashReader, err := hash.NewReader(bytes.NewReader([]byte{}), 0, "", "", 0, true)
 		if err != nil {
@@ -506,13 +1127,47 @@ func (layer *gatewayLayer) PutObject(ctx context.Context, bucketName, objectPath
 		data = minio.NewPutObjReader(hashReader, nil, nil)
 	}
 
-	upload, err := layer.project.UploadObject(ctx, bucketName, objectPath, nil)
+	opts.UserDefined = layer.gateway.applyMetadataDefaults(bucketName, opts.UserDefined)
+	layer.gateway.markAlias(opts.UserDefined)
+
+	if layer.gateway.config.DedupBucket != "" && data.SHA256HexString() != "" {
+		return layer.putDeduplicatedObject(ctx, project, bucketName, objectPath, data, opts)
+	}
+
+	// verified uploads are stored under a temporary key and only copied
+	// into place once verified, so a corrupt upload doesn't replace the
+	// previous object
+	key := objectPath
+	if layer.gateway.config.VerifyUploads {
+		key, err = temporaryKey(verifyPrefix)
+		if err != nil {
+			return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+		}
+	}
+
+	upload, err := project.UploadObject(ctx, bucketName, key, nil)
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	mirror, err := layer.startMirror(ctx, bucketName, objectPath)
+	if err != nil {
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	content, err := layer.gateway.encode(ctx, bucketName, objectPath, data, opts.UserDefined)
 	if err != nil {
+		mirror.abort()
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
 		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
 	}
 
-	_, err = io.Copy(upload, data)
+	_, err = io.Copy(mirror.writer(upload), content)
 	if err != nil {
+		mirror.abort()
 		abortErr := upload.Abort()
 		err = errs.Combine(err, abortErr)
 		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
@@ -520,6 +1175,16 @@ func (layer *gatewayLayer) PutObject(ctx context.Context, bucketName, objectPath
 
 	opts.UserDefined["s3:etag"] = hex.EncodeToString(data.MD5Current())
 	err = upload.SetCustomMetadata(ctx, opts.UserDefined)
+	if err != nil {
+		mirror.abort()
+		abortErr := upload.Abort()
+		err = errs.Combine(err, abortErr)
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	// the mirror is committed first, so a required mirror can still fail
+	// the upload
+	err = mirror.commit(ctx, opts.UserDefined)
 	if err != nil {
 		abortErr := upload.Abort()
 		err = errs.Combine(err, abortErr)
@@ -528,15 +1193,35 @@ func (layer *gatewayLayer) PutObject(ctx context.Context, bucketName, objectPath
 
 	err = upload.Commit()
 	if err != nil {
+		mirror.remove(ctx)
 		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
 	}
 
-	return minioObjectInfo(bucketName, opts.UserDefined["s3:etag"], upload.Info()), nil
+	object := upload.Info()
+	if layer.gateway.config.VerifyUploads {
+		object, err = layer.placeVerifiedUpload(ctx, project, bucketName, object, objectPath, opts.UserDefined["s3:etag"])
+		if err != nil {
+			mirror.remove(ctx)
+			return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+		}
+	}
+
+	return minioObjectInfo(bucketName, opts.UserDefined["s3:etag"], object), nil
 }
 
 func (layer *gatewayLayer) Shutdown(ctx context.Context) (err error) {
 	defer mon.Task()(&ctx)(&err)
-	return layer.project.Close()
+
+	// minio shuts down the layer when the process exits, so flush what the
+	// gateway has collected meanwhile
+	err = layer.gateway.Close()
+
+	layer.projectMu.Lock()
+	defer layer.projectMu.Unlock()
+	if layer.mirror != nil {
+		err = errs.Combine(err, layer.mirror.Close())
+	}
+	return errs.Combine(err, layer.project.Close())
 }
 
 func (layer *gatewayLayer) StorageInfo(ctx context.Context, local bool) minio.StorageInfo {
@@ -546,8 +1231,15 @@ func (layer *gatewayLayer) StorageInfo(ctx context.Context, local bool) minio.St
 	return info
 }
 
+// GetBucketPolicy returns the policy that allows anonymous read access to all
+// buckets in website mode.
+//
+// Minio authorizes object ACL requests with the GetBucketPolicy action, so the
+// policy allows it too, which lets anonymous requests read this policy. The
+// ACLs of objects that anonymous requests may read are public-read, see
+// responseWriter, and object ACL requests for other objects are denied.
 func (layer *gatewayLayer) GetBucketPolicy(ctx context.Context, bucket string) (*policy.Policy, error) {
-	if !layer.gateway.website {
+	if !layer.gateway.config.Website {
 		return &policy.Policy{}, nil
 	}
 
@@ -561,6 +1253,7 @@ func (layer *gatewayLayer) GetBucketPolicy(ctx context.Context, bucket string) (
 				Actions: policy.NewActionSet(
 					policy.GetBucketLocationAction,
 					policy.ListBucketAction,
+					policy.GetBucketPolicyAction,
 				),
 				Resources: policy.NewResourceSet(
 					policy.NewResource(bucket, ""),
@@ -580,6 +1273,71 @@ func (layer *gatewayLayer) GetBucketPolicy(ctx context.Context, bucket string) (
 	}, nil
 }
 
+// websiteAccessAllowed returns whether the website policy allows the request
+// served with ctx to read the content of the object. Only anonymous requests
+// are restricted, as minio checks the bucket policy only for them.
+func (layer *gatewayLayer) websiteAccessAllowed(ctx context.Context, object *uplink.Object) bool {
+	return !layer.gateway.config.Website || !getRequest(ctx).anonymous || layer.publicRead(object)
+}
+
+// publicRead returns whether anonymous requests may read the content of the
+// object, i.e. whether its ACL is public-read rather than private.
+func (layer *gatewayLayer) publicRead(object *uplink.Object) bool {
+	config := layer.gateway.config
+	if !config.Website {
+		return false
+	}
+	if config.WebsiteTag == "" {
+		return true
+	}
+
+	tags, err := objectTags(object)
+	if err != nil {
+		return false
+	}
+
+	for _, tag := range tags.TagSet.Tags {
+		if tag.String() == config.WebsiteTag {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptionPathHeader selects the encryption path to read an object under.
+const encryptionPathHeader = "X-Storj-Encryption-Path"
+
+// encryptionPath returns the path to access the object under. Clients may
+// select one of the configured encryption paths of the bucket with the
+// encryption path header. The object is then read with the key derived for
+// its key under that path.
+//
+// uplink doesn't allow to override encryption keys of an access, so only
+// paths within the grant of the gateway can be selected.
+func (layer *gatewayLayer) encryptionPath(header http.Header, bucketName, objectPath string) (string, error) {
+	path := header.Get(encryptionPathHeader)
+	if path == "" {
+		return objectPath, nil
+	}
+
+	for _, allowed := range layer.gateway.config.EncryptionPaths {
+		if allowed == bucketName+"/"+path {
+			return path + objectPath, nil
+		}
+	}
+	return "", minio.PrefixAccessDenied{Bucket: bucketName, Object: objectPath}
+}
+
+// objectTags returns the tags stored with the object.
+func objectTags(object *uplink.Object) (tagging.Tagging, error) {
+	for k, v := range object.Custom {
+		if strings.EqualFold(k, xhttp.AmzObjectTagging) {
+			return tagging.FromString(v)
+		}
+	}
+	return tagging.Tagging{}, nil
+}
+
 // GetBucketSSEConfig returns bucket encryption config on given bucket
 func (layer *gatewayLayer) GetBucketSSEConfig(ctx context.Context, bucket string) (*bucketsse.BucketSSEConfig, error) {
 	return &bucketsse.BucketSSEConfig{}, nil
@@ -591,7 +1349,7 @@ func (layer *gatewayLayer) IsReady(ctx context.Context) bool {
 }
 
 func (layer *gatewayLayer) isSatelliteOnline(ctx context.Context) bool {
-	project, err := layer.gateway.config.OpenProject(ctx, layer.gateway.access)
+	project, err := layer.gateway.uplinkConfig.OpenProject(ctx, layer.gateway.access)
 	if err != nil {
 		return false
 	}
@@ -628,6 +1386,34 @@ func convertError(err error, bucket, object string) error {
 	return err
 }
 
+// ignoreRange makes the response to the request of ctx send the whole object
+// instead of the range, because the object has changed since the client
+// started downloading it. Minio has already decided on a partial response
+// based on the Range header and derives the response headers from the range,
+// so the range is widened to the whole object and the response is turned
+// into a 200 OK without Content-Range, see responseWriter. It returns the
+// range to download, i.e. nil.
+func ignoreRange(ctx context.Context, rangeSpec *minio.HTTPRangeSpec) *minio.HTTPRangeSpec {
+	*rangeSpec = minio.HTTPRangeSpec{Start: 0, End: -1}
+	getRequest(ctx).rangeIgnored = true
+	return nil
+}
+
+// ifRangeMatches returns whether the If-Range validator, either an ETag or
+// an HTTP date, still matches the object. An empty validator always matches.
+func ifRangeMatches(ifRange string, object *uplink.Object) bool {
+	if ifRange == "" {
+		return true
+	}
+
+	if modified, err := http.ParseTime(ifRange); err == nil {
+		return !object.System.Created.Truncate(time.Second).After(modified)
+	}
+
+	etag := object.Custom["s3:etag"]
+	return etag != "" && strings.Trim(ifRange, `"`) == strings.Trim(etag, `"`)
+}
+
 func minioObjectInfo(bucket, etag string, object *uplink.Object) minio.ObjectInfo {
 	contentType := ""
 	for k, v := range object.Custom {
@@ -642,10 +1428,11 @@ func minioObjectInfo(bucket, etag string, object *uplink.Object) minio.ObjectInf
 	return minio.ObjectInfo{
 		Bucket:      bucket,
 		Name:        object.Key,
-		Size:        object.System.ContentLength,
+		Size:        objectSize(object),
 		ETag:        etag,
 		ModTime:     object.System.Created,
 		ContentType: contentType,
 		UserDefined: object.Custom,
+		Parts:       objectParts(object),
 	}
 }
@@ -0,0 +1,162 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// IndexConfig configures the index of objects by metadata values.
+type IndexConfig struct {
+	Keys    string `help:"comma separated metadata keys to index objects by for search, indexing is disabled if empty" default:""`
+	Prefix  string `help:"reserved key prefix in each bucket to keep the metadata index under" default:".index/"`
+	Address string `help:"address to serve the metadata index query endpoint on, it's not authenticated and must not be exposed publicly" default:""`
+}
+
+// indexing reports whether objects are indexed by metadata.
+func (gateway *Gateway) indexing() bool {
+	return gateway.config.Index.Keys != ""
+}
+
+// isIndexKey reports whether key is in the reserved prefix of the index.
+func (gateway *Gateway) isIndexKey(key string) bool {
+	return gateway.indexing() && strings.HasPrefix(key, gateway.config.Index.Prefix)
+}
+
+// indexEntries returns the keys of the index entries for the object with
+// the given metadata.
+func (gateway *Gateway) indexEntries(objectPath string, metadata map[string]string) map[string]struct{} {
+	entries := make(map[string]struct{})
+	for _, indexed := range strings.Split(gateway.config.Index.Keys, ",") {
+		indexed = strings.ToLower(strings.TrimSpace(indexed))
+		if indexed == "" {
+			continue
+		}
+		for key, value := range metadata {
+			key = strings.ToLower(key)
+			if key == indexed || key == "x-amz-meta-"+indexed {
+				entries[gateway.indexPrefix(indexed, value)+objectPath] = struct{}{}
+			}
+		}
+	}
+	return entries
+}
+
+// indexPrefix returns the prefix of the index entries for objects with the
+// metadata key set to value.
+func (gateway *Gateway) indexPrefix(key, value string) string {
+	return gateway.config.Index.Prefix + url.PathEscape(strings.ToLower(key)) + "/" + url.PathEscape(value) + "/"
+}
+
+// indexedMetadata returns the metadata of the object, which is about to be
+// replaced or deleted, or nil if it doesn't exist.
+func (layer *gatewayLayer) indexedMetadata(ctx context.Context, project *uplink.Project, bucketName, objectPath string) map[string]string {
+	object, err := project.StatObject(ctx, bucketName, objectPath)
+	if err != nil {
+		return nil
+	}
+	return object.Custom
+}
+
+// updateIndex replaces the index entries of the object with previous metadata
+// by the entries for its current metadata. The object itself is already
+// stored at this point, so failures are only counted.
+func (layer *gatewayLayer) updateIndex(ctx context.Context, project *uplink.Project, bucketName, objectPath string, previous, current map[string]string) {
+	var err error
+	defer mon.Task()(&ctx)(&err)
+
+	gateway := layer.gateway
+	stale := gateway.indexEntries(objectPath, previous)
+	for entry := range gateway.indexEntries(objectPath, current) {
+		if _, ok := stale[entry]; ok {
+			delete(stale, entry)
+			continue
+		}
+
+		upload, uploadErr := project.UploadObject(ctx, bucketName, entry, nil)
+		if uploadErr != nil {
+			err = errs.Combine(err, uploadErr)
+			continue
+		}
+		err = errs.Combine(err, upload.Commit())
+	}
+
+	for entry := range stale {
+		_, deleteErr := project.DeleteObject(ctx, bucketName, entry)
+		err = errs.Combine(err, deleteErr)
+	}
+
+	if err != nil {
+		mon.Counter("index_update_failed").Inc(1)
+	}
+}
+
+// SearchObjects returns the keys of the objects in the bucket with the
+// metadata key set to value.
+func (gateway *Gateway) SearchObjects(ctx context.Context, bucketName, key, value string) (keys []string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if !gateway.indexing() {
+		return nil, Error.New("metadata indexing is disabled")
+	}
+
+	project, err := gateway.uplinkConfig.OpenProject(ctx, gateway.access)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, project.Close()) }()
+
+	prefix := gateway.indexPrefix(key, value)
+	list := project.ListObjects(ctx, bucketName, &uplink.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+	for list.Next() {
+		keys = append(keys, strings.TrimPrefix(list.Item().Key, prefix))
+	}
+	if list.Err() != nil {
+		return nil, Error.Wrap(list.Err())
+	}
+
+	return keys, nil
+}
+
+// IndexHandler returns the handler of the metadata index query endpoint.
+// It responds to "GET ?bucket=...&key=...&value=..." with a JSON array
+// of the keys of the matching objects.
+func (gateway *Gateway) IndexHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		bucket, key := query.Get("bucket"), query.Get("key")
+		if bucket == "" || key == "" {
+			http.Error(w, "bucket and key are required", http.StatusBadRequest)
+			return
+		}
+
+		keys, err := gateway.SearchObjects(r.Context(), bucket, key, query.Get("value"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if keys == nil {
+			keys = []string{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(keys)
+	})
+}
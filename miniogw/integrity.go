@@ -0,0 +1,117 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// ErrIntegrity is the errs class of downloads whose content doesn't match
+// the size of the stored object and of uploads whose stored content doesn't
+// match the uploaded content.
+var ErrIntegrity = errs.Class("integrity error")
+
+// sizeReader fails with an integrity error if the reader doesn't return
+// exactly the expected number of bytes, instead of silently ending early.
+type sizeReader struct {
+	reader   io.Reader
+	expected int64
+	read     int64
+}
+
+// validateSize wraps the content of a download of length bytes from offset of
+// the object with the size. A negative length means the rest of the object.
+func validateSize(content io.Reader, size, offset, length int64) io.Reader {
+	if length < 0 {
+		length = size - offset
+	}
+	return &sizeReader{reader: content, expected: length}
+}
+
+// Read implements io.Reader.
+func (reader *sizeReader) Read(p []byte) (n int, err error) {
+	n, err = reader.reader.Read(p)
+	reader.read += int64(n)
+
+	if reader.read > reader.expected {
+		mon.Counter("download_size_mismatch").Inc(1)
+		return n, ErrIntegrity.New("downloaded more than the expected %d bytes", reader.expected)
+	}
+	if err == io.EOF && reader.read < reader.expected {
+		mon.Counter("download_size_mismatch").Inc(1)
+		return n, ErrIntegrity.New("downloaded %d bytes instead of the expected %d bytes", reader.read, reader.expected)
+	}
+	return n, err
+}
+
+// verifyPrefix is the key prefix of the temporary objects uploads are
+// verified under, which aren't listed.
+const verifyPrefix = ".gateway-verify/"
+
+// isVerifyKey reports whether key is a temporary object of a verified upload.
+func isVerifyKey(key string) bool {
+	return strings.HasPrefix(key, verifyPrefix)
+}
+
+// verifyUpload reads the object committed under the temporary key back and
+// compares the MD5 of its content with the ETag computed while it was
+// uploaded. The content is decoded as the content of objectPath, which it
+// was encoded for.
+func (layer *gatewayLayer) verifyUpload(ctx context.Context, project *uplink.Project, bucketName, temporary, objectPath, etag string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	download, err := project.DownloadObject(ctx, bucketName, temporary, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, download.Close()) }()
+
+	object := *download.Info()
+	object.Key = objectPath
+	content, err := layer.gateway.decode(ctx, bucketName, &object, 0, download)
+	if err != nil {
+		return err
+	}
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return err
+	}
+
+	if stored := hex.EncodeToString(hash.Sum(nil)); stored != etag {
+		mon.Counter("upload_checksum_mismatch").Inc(1)
+		return ErrIntegrity.New("stored content of %q has MD5 %s instead of the uploaded %s", objectPath, stored, etag)
+	}
+	return nil
+}
+
+// placeVerifiedUpload verifies the upload committed as the temporary object
+// and copies its stored content to objectPath. The temporary object is
+// removed in any case, so a failed verification keeps the previous object
+// at objectPath.
+func (layer *gatewayLayer) placeVerifiedUpload(ctx context.Context, project *uplink.Project, bucketName string, temporary *uplink.Object, objectPath, etag string) (_ *uplink.Object, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	defer func() {
+		_, deleteErr := project.DeleteObject(ctx, bucketName, temporary.Key)
+		if deleteErr != nil {
+			mon.Counter("verify_cleanup_failed").Inc(1)
+		}
+	}()
+
+	err = layer.verifyUpload(ctx, project, bucketName, temporary.Key, objectPath, etag)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyStored(ctx, project, temporary, bucketName, objectPath, temporary.Custom)
+}
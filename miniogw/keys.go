@@ -0,0 +1,376 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/bucket/object/tagging"
+)
+
+// keyMapping maps the bucket names and object keys clients use to those of
+// the wrapped layer, and the keys back to the ones clients see.
+type keyMapping interface {
+	// bucket returns the bucket of the wrapped layer the client addresses.
+	bucket(bucket string) string
+	// check returns an error if the client may not address the object key
+	// or key prefix in the bucket.
+	check(ctx context.Context, bucket, object string) error
+	// key returns the key of the wrapped layer the client addresses.
+	key(ctx context.Context, object string) string
+	// object returns the key of the wrapped layer as seen by the client.
+	object(ctx context.Context, key string) string
+}
+
+// identityMapping maps names to themselves, for mappings to embed.
+type identityMapping struct{}
+
+func (identityMapping) bucket(bucket string) string { return bucket }
+
+func (identityMapping) check(ctx context.Context, bucket, object string) error { return nil }
+
+func (identityMapping) key(ctx context.Context, object string) string { return object }
+
+func (identityMapping) object(ctx context.Context, key string) string { return key }
+
+// layerKeys maps the bucket names and object keys of all object requests with
+// its mapping on the way in and maps the keys in the responses back on the
+// way out.
+type layerKeys struct {
+	minio.ObjectLayer
+	mapping keyMapping
+	// aliases is whether the targets of alias objects are mapped as well.
+	aliases bool
+}
+
+// mapKeys returns a wrapper of layer that maps the keys with mapping.
+func (gateway *Gateway) mapKeys(layer minio.ObjectLayer, mapping keyMapping) *layerKeys {
+	return &layerKeys{ObjectLayer: layer, mapping: mapping, aliases: gateway.config.ObjectAliases}
+}
+
+// key checks whether the client may address object in bucket and returns
+// the key of the wrapped layer.
+func (keys *layerKeys) key(ctx context.Context, bucket, object string) (string, error) {
+	if err := keys.mapping.check(ctx, bucket, object); err != nil {
+		return "", err
+	}
+	return keys.mapping.key(ctx, object), nil
+}
+
+// aliasTarget returns the metadata of an uploaded object with the target of
+// alias objects mapped like the key of the object, so alias objects can only
+// target keys the client can address.
+func (keys *layerKeys) aliasTarget(ctx context.Context, bucket string, metadata map[string]string) (map[string]string, error) {
+	if !keys.aliases {
+		return metadata, nil
+	}
+
+	mapped := make(map[string]string, len(metadata))
+	for name, value := range metadata {
+		if strings.EqualFold(name, aliasHeader) && value != "" {
+			target, err := keys.key(ctx, bucket, value)
+			if err != nil {
+				return nil, err
+			}
+			value = target
+		}
+		mapped[name] = value
+	}
+	return mapped, nil
+}
+
+// marker returns the listing marker of the wrapped layer, keeping empty
+// markers empty. Markers only position listings, so they aren't checked.
+func (keys *layerKeys) marker(ctx context.Context, marker string) string {
+	if marker == "" {
+		return ""
+	}
+	return keys.mapping.key(ctx, marker)
+}
+
+// error returns err with the bucket and the key as seen by the client.
+func (keys *layerKeys) error(ctx context.Context, bucket string, err error) error {
+	switch err := err.(type) {
+	case minio.BucketNotFound:
+		err.Bucket = bucket
+		return err
+	case minio.BucketNotEmpty:
+		err.Bucket = bucket
+		return err
+	case minio.InvalidUploadID:
+		err.Bucket = bucket
+		return err
+	case minio.ObjectNotFound:
+		err.Bucket, err.Object = bucket, keys.mapping.object(ctx, err.Object)
+		return err
+	case minio.ObjectNameInvalid:
+		err.Bucket, err.Object = bucket, keys.mapping.object(ctx, err.Object)
+		return err
+	case minio.PrefixAccessDenied:
+		err.Bucket, err.Object = bucket, keys.mapping.object(ctx, err.Object)
+		return err
+	}
+	return err
+}
+
+func (keys *layerKeys) objectInfo(ctx context.Context, bucket string, info minio.ObjectInfo) minio.ObjectInfo {
+	if info.Bucket != "" {
+		info.Bucket = bucket
+	}
+	info.Name = keys.mapping.object(ctx, info.Name)
+	return info
+}
+
+func (keys *layerKeys) objectInfos(ctx context.Context, bucket string, infos []minio.ObjectInfo) []minio.ObjectInfo {
+	for i := range infos {
+		infos[i] = keys.objectInfo(ctx, bucket, infos[i])
+	}
+	return infos
+}
+
+func (keys *layerKeys) objects(ctx context.Context, objects []string) []string {
+	for i := range objects {
+		objects[i] = keys.mapping.object(ctx, objects[i])
+	}
+	return objects
+}
+
+func (keys *layerKeys) NewNSLock(ctx context.Context, bucket string, objects ...string) minio.RWLocker {
+	mapped := make([]string, len(objects))
+	for i, object := range objects {
+		mapped[i] = keys.mapping.key(ctx, object)
+	}
+	return keys.ObjectLayer.NewNSLock(ctx, keys.mapping.bucket(bucket), mapped...)
+}
+
+func (keys *layerKeys) GetBucketInfo(ctx context.Context, bucket string) (bucketInfo minio.BucketInfo, err error) {
+	bucketInfo, err = keys.ObjectLayer.GetBucketInfo(ctx, keys.mapping.bucket(bucket))
+	if err == nil {
+		bucketInfo.Name = bucket
+	}
+	return bucketInfo, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
+	key, err := keys.key(ctx, bucket, prefix)
+	if err != nil {
+		return minio.ListObjectsInfo{}, err
+	}
+	result, err = keys.ObjectLayer.ListObjects(ctx, keys.mapping.bucket(bucket), key, keys.marker(ctx, marker), delimiter, maxKeys)
+	result.NextMarker = keys.mapping.object(ctx, result.NextMarker)
+	result.Objects = keys.objectInfos(ctx, bucket, result.Objects)
+	result.Prefixes = keys.objects(ctx, result.Prefixes)
+	return result, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
+	key, err := keys.key(ctx, bucket, prefix)
+	if err != nil {
+		return minio.ListObjectsV2Info{}, err
+	}
+	result, err = keys.ObjectLayer.ListObjectsV2(ctx, keys.mapping.bucket(bucket), key, keys.marker(ctx, continuationToken), delimiter, maxKeys, fetchOwner, keys.marker(ctx, startAfter))
+	result.ContinuationToken = keys.mapping.object(ctx, result.ContinuationToken)
+	result.NextContinuationToken = keys.mapping.object(ctx, result.NextContinuationToken)
+	result.Objects = keys.objectInfos(ctx, bucket, result.Objects)
+	result.Prefixes = keys.objects(ctx, result.Prefixes)
+	return result, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return nil, err
+	}
+	reader, err = keys.ObjectLayer.GetObjectNInfo(ctx, keys.mapping.bucket(bucket), key, rs, h, lockType, opts)
+	if reader != nil {
+		reader.ObjInfo = keys.objectInfo(ctx, bucket, reader.ObjInfo)
+	}
+	return reader, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return err
+	}
+	return keys.error(ctx, bucket, keys.ObjectLayer.GetObject(ctx, keys.mapping.bucket(bucket), key, startOffset, length, writer, etag, opts))
+}
+
+func (keys *layerKeys) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	objInfo, err = keys.ObjectLayer.GetObjectInfo(ctx, keys.mapping.bucket(bucket), key, opts)
+	return keys.objectInfo(ctx, bucket, objInfo), keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	opts.UserDefined, err = keys.aliasTarget(ctx, bucket, opts.UserDefined)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	objInfo, err = keys.ObjectLayer.PutObject(ctx, keys.mapping.bucket(bucket), key, data, opts)
+	return keys.objectInfo(ctx, bucket, objInfo), keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	srcKey, err := keys.key(ctx, srcBucket, srcObject)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	destKey, err := keys.key(ctx, destBucket, destObject)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	srcInfo.Bucket = keys.mapping.bucket(srcInfo.Bucket)
+	srcInfo.Name = keys.mapping.key(ctx, srcInfo.Name)
+	objInfo, err = keys.ObjectLayer.CopyObject(ctx, keys.mapping.bucket(srcBucket), srcKey, keys.mapping.bucket(destBucket), destKey, srcInfo, srcOpts, destOpts)
+	return keys.objectInfo(ctx, destBucket, objInfo), keys.error(ctx, destBucket, err)
+}
+
+func (keys *layerKeys) DeleteObject(ctx context.Context, bucket, object string) error {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return err
+	}
+	return keys.error(ctx, bucket, keys.ObjectLayer.DeleteObject(ctx, keys.mapping.bucket(bucket), key))
+}
+
+func (keys *layerKeys) DeleteObjects(ctx context.Context, bucket string, objects []string) ([]error, error) {
+	errors := make([]error, len(objects))
+
+	var mapped []string
+	var indexes []int
+	for i, object := range objects {
+		key, err := keys.key(ctx, bucket, object)
+		if err != nil {
+			errors[i] = err
+			continue
+		}
+		mapped = append(mapped, key)
+		indexes = append(indexes, i)
+	}
+	if len(mapped) == 0 {
+		return errors, nil
+	}
+
+	deleted, err := keys.ObjectLayer.DeleteObjects(ctx, keys.mapping.bucket(bucket), mapped)
+	for i := range deleted {
+		errors[indexes[i]] = keys.error(ctx, bucket, deleted[i])
+	}
+	return errors, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (result minio.ListMultipartsInfo, err error) {
+	key, err := keys.key(ctx, bucket, prefix)
+	if err != nil {
+		return minio.ListMultipartsInfo{}, err
+	}
+	result, err = keys.ObjectLayer.ListMultipartUploads(ctx, keys.mapping.bucket(bucket), key, keys.marker(ctx, keyMarker), uploadIDMarker, delimiter, maxUploads)
+	result.KeyMarker = keys.mapping.object(ctx, result.KeyMarker)
+	result.NextKeyMarker = keys.mapping.object(ctx, result.NextKeyMarker)
+	result.Prefix = keys.mapping.object(ctx, result.Prefix)
+	result.CommonPrefixes = keys.objects(ctx, result.CommonPrefixes)
+	for i := range result.Uploads {
+		result.Uploads[i].Object = keys.mapping.object(ctx, result.Uploads[i].Object)
+	}
+	return result, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return "", err
+	}
+	uploadID, err = keys.ObjectLayer.NewMultipartUpload(ctx, keys.mapping.bucket(bucket), key, opts)
+	return uploadID, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) CopyObjectPart(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, uploadID string, partID int, startOffset int64, length int64, srcInfo minio.ObjectInfo, srcOpts, dstOpts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	srcKey, err := keys.key(ctx, srcBucket, srcObject)
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+	destKey, err := keys.key(ctx, destBucket, destObject)
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+	srcInfo.Bucket = keys.mapping.bucket(srcInfo.Bucket)
+	srcInfo.Name = keys.mapping.key(ctx, srcInfo.Name)
+	info, err = keys.ObjectLayer.CopyObjectPart(ctx, keys.mapping.bucket(srcBucket), srcKey, keys.mapping.bucket(destBucket), destKey, uploadID, partID, startOffset, length, srcInfo, srcOpts, dstOpts)
+	return info, keys.error(ctx, destBucket, err)
+}
+
+func (keys *layerKeys) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return minio.PartInfo{}, err
+	}
+	info, err = keys.ObjectLayer.PutObjectPart(ctx, keys.mapping.bucket(bucket), key, uploadID, partID, data, opts)
+	return info, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker int, maxParts int, opts minio.ObjectOptions) (result minio.ListPartsInfo, err error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return minio.ListPartsInfo{}, err
+	}
+	result, err = keys.ObjectLayer.ListObjectParts(ctx, keys.mapping.bucket(bucket), key, uploadID, partNumberMarker, maxParts, opts)
+	if result.Bucket != "" {
+		result.Bucket = bucket
+	}
+	result.Object = keys.mapping.object(ctx, result.Object)
+	return result, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string) error {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return err
+	}
+	return keys.error(ctx, bucket, keys.ObjectLayer.AbortMultipartUpload(ctx, keys.mapping.bucket(bucket), key, uploadID))
+}
+
+func (keys *layerKeys) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	objInfo, err = keys.ObjectLayer.CompleteMultipartUpload(ctx, keys.mapping.bucket(bucket), key, uploadID, uploadedParts, opts)
+	return keys.objectInfo(ctx, bucket, objInfo), keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) PutObjectTag(ctx context.Context, bucket, object string, tags string) error {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return err
+	}
+	return keys.error(ctx, bucket, keys.ObjectLayer.PutObjectTag(ctx, keys.mapping.bucket(bucket), key, tags))
+}
+
+func (keys *layerKeys) GetObjectTag(ctx context.Context, bucket, object string) (tagging.Tagging, error) {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return tagging.Tagging{}, err
+	}
+	tags, err := keys.ObjectLayer.GetObjectTag(ctx, keys.mapping.bucket(bucket), key)
+	return tags, keys.error(ctx, bucket, err)
+}
+
+func (keys *layerKeys) DeleteObjectTag(ctx context.Context, bucket, object string) error {
+	key, err := keys.key(ctx, bucket, object)
+	if err != nil {
+		return err
+	}
+	return keys.error(ctx, bucket, keys.ObjectLayer.DeleteObjectTag(ctx, keys.mapping.bucket(bucket), key))
+}
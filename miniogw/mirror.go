@@ -0,0 +1,156 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// MirrorConfig configures mirroring of uploaded objects into a secondary
+// project. Uploads, completed multipart uploads and copies are mirrored.
+// Deduplicated uploads and copies, which only reference the content, and
+// metadata-only copies aren't.
+type MirrorConfig struct {
+	Access   string `help:"access grant of the project to mirror uploaded objects to, mirroring is disabled if empty" default:""`
+	Bucket   string `help:"bucket to mirror uploaded objects to, the bucket of the object if empty" default:""`
+	Required bool   `help:"fail uploads that can't be mirrored instead of only counting the failures" default:"false"`
+}
+
+// openMirror opens the project to mirror uploads to, or returns nil if
+// mirroring is disabled.
+func (gateway *Gateway) openMirror(ctx context.Context) (_ *uplink.Project, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if gateway.config.Mirror.Access == "" {
+		return nil, nil
+	}
+
+	access, err := uplink.ParseAccess(gateway.config.Mirror.Access)
+	if err != nil {
+		return nil, err
+	}
+	return gateway.uplinkConfig.OpenProject(ctx, access)
+}
+
+// mirrorUpload uploads the data written to the primary upload into the mirror
+// project as well. Unless mirroring is required, failures only stop the
+// mirroring and the primary upload continues.
+//
+// A nil mirrorUpload is valid and does nothing.
+type mirrorUpload struct {
+	project  *uplink.Project
+	bucket   string
+	key      string
+	required bool
+
+	upload *uplink.Upload
+	err    error
+}
+
+// startMirror starts mirroring the upload of the object, if enabled.
+func (layer *gatewayLayer) startMirror(ctx context.Context, bucketName, objectPath string) (*mirrorUpload, error) {
+	if layer.mirror == nil {
+		return nil, nil
+	}
+
+	config := layer.gateway.config.Mirror
+	mirror := &mirrorUpload{
+		project:  layer.mirror,
+		bucket:   bucketName,
+		key:      objectPath,
+		required: config.Required,
+	}
+	if config.Bucket != "" {
+		mirror.bucket = config.Bucket
+	}
+
+	mirror.upload, mirror.err = mirror.project.UploadObject(ctx, mirror.bucket, mirror.key, nil)
+	if mirror.err != nil {
+		mon.Counter("mirror_failed").Inc(1)
+		if mirror.required {
+			return nil, mirror.err
+		}
+	}
+	return mirror, nil
+}
+
+// writer returns a writer to the primary upload and the mirror.
+func (mirror *mirrorUpload) writer(primary io.Writer) io.Writer {
+	if mirror == nil {
+		return primary
+	}
+	return io.MultiWriter(primary, mirror)
+}
+
+// Write implements io.Writer.
+func (mirror *mirrorUpload) Write(p []byte) (n int, err error) {
+	if mirror.err != nil {
+		return len(p), nil
+	}
+
+	n, err = mirror.upload.Write(p)
+	if err != nil {
+		mirror.fail(err)
+		if mirror.required {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
+
+// commit commits the mirrored object with the metadata. It returns an error
+// only if mirroring is required.
+func (mirror *mirrorUpload) commit(ctx context.Context, metadata uplink.CustomMetadata) error {
+	if mirror == nil || mirror.err != nil {
+		return mirror.result()
+	}
+
+	err := mirror.upload.SetCustomMetadata(ctx, metadata)
+	if err != nil {
+		mirror.fail(err)
+		return mirror.result()
+	}
+
+	err = mirror.upload.Commit()
+	if err != nil {
+		mirror.err = err
+		mon.Counter("mirror_failed").Inc(1)
+	}
+	return mirror.result()
+}
+
+// abort aborts the mirroring of an upload that failed.
+func (mirror *mirrorUpload) abort() {
+	if mirror != nil && mirror.err == nil {
+		mirror.err = errs.New("upload aborted")
+		_ = mirror.upload.Abort()
+	}
+}
+
+// remove deletes the mirrored object of an upload that failed after the
+// mirrored object was committed.
+func (mirror *mirrorUpload) remove(ctx context.Context) {
+	if mirror != nil && mirror.err == nil {
+		_, _ = mirror.project.DeleteObject(ctx, mirror.bucket, mirror.key)
+	}
+}
+
+func (mirror *mirrorUpload) fail(err error) {
+	mirror.err = err
+	_ = mirror.upload.Abort()
+	mon.Counter("mirror_failed").Inc(1)
+}
+
+// result returns the mirroring error, if mirroring is required.
+func (mirror *mirrorUpload) result() error {
+	if mirror == nil || !mirror.required {
+		return nil
+	}
+	return mirror.err
+}
@@ -7,7 +7,9 @@ import (
 	"context"
 	"crypto/md5" /* #nosec G501 */ // Is only used for calculating a hash of the ETags of the all the parts of a multipart upload.
 	"encoding/hex"
+	"fmt"
 	"io"
+	"net/http"
 	"sort"
 	"strconv"
 	"strings"
@@ -15,6 +17,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	miniov6 "github.com/minio/minio-go/v6"
 	minio "github.com/minio/minio/cmd"
 	"github.com/minio/minio/pkg/hash"
 	"github.com/zeebo/errs"
@@ -23,10 +26,27 @@ import (
 	"storj.io/uplink"
 )
 
+// partsKey is the custom metadata key of objects uploaded with a multipart
+// upload. Its value lists the numbers and sizes of the parts, which may have
+// gaps in their numbers.
+const partsKey = "s3:parts"
+
 func (layer *gatewayLayer) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
 	ctx = context2.WithoutCancellation(ctx)
 
 	defer mon.Task()(&ctx)(&err)
+
+	project, release, err := layer.openProject(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		// the project is released when the upload is done
+		if err != nil {
+			release()
+		}
+	}()
+	opts.UserDefined = layer.gateway.applyMetadataDefaults(bucket, opts.UserDefined)
 	if err := uplink.CustomMetadata(opts.UserDefined).Verify(); err != nil {
 		return "", err
 	}
@@ -37,19 +57,47 @@ func (layer *gatewayLayer) NewMultipartUpload(ctx context.Context, bucket, objec
 	if err != nil {
 		return "", err
 	}
+	upload.Stream.gaps = layer.gateway.config.AllowPartNumberGaps
+
+	// the upload deletes the previous object, so its indexed metadata is
+	// read first
+	var previous map[string]string
+	if layer.gateway.indexing() {
+		previous = layer.indexedMetadata(ctx, project, bucket, object)
+	}
 
 	// TODO: this can now be done without this separate goroutine
-	stream, err := layer.project.UploadObject(ctx, bucket, object, nil)
+	stream, err := project.UploadObject(ctx, bucket, object, nil)
 	if err != nil {
 		uploads.RemoveByID(upload.ID)
 		upload.fail(err)
 		return "", err
 	}
 
+	// the parts are encoded as they are streamed into the object, as the
+	// transforms apply to the whole content
+	metadata := uplink.CustomMetadata(opts.UserDefined).Clone()
+	content, err := layer.gateway.encode(ctx, bucket, object, upload.Stream, metadata)
+	if err != nil {
+		uploads.RemoveByID(upload.ID)
+		upload.fail(errs.Combine(err, stream.Abort()))
+		return "", err
+	}
+
+	mirror, err := layer.startMirror(ctx, bucket, object)
+	if err != nil {
+		uploads.RemoveByID(upload.ID)
+		upload.fail(errs.Combine(err, stream.Abort()))
+		return "", err
+	}
+
 	go func() {
-		_, err = io.Copy(stream, upload.Stream)
+		defer release()
+
+		_, err := io.Copy(mirror.writer(stream), content)
 		if err != nil {
 			uploads.RemoveByID(upload.ID)
+			mirror.abort()
 			abortErr := stream.Abort()
 			upload.fail(errs.Combine(err, abortErr))
 			return
@@ -58,15 +106,27 @@ func (layer *gatewayLayer) NewMultipartUpload(ctx context.Context, bucket, objec
 		etag, etagErr := upload.etag()
 		if etagErr != nil {
 			uploads.RemoveByID(upload.ID)
+			mirror.abort()
 			abortErr := stream.Abort()
 			upload.fail(errs.Combine(etagErr, abortErr))
 			return
 		}
 
-		metadata := uplink.CustomMetadata(opts.UserDefined).Clone()
 		metadata["s3:etag"] = etag
+		metadata[partsKey] = upload.parts()
 
 		err = stream.SetCustomMetadata(ctx, metadata)
+		if err != nil {
+			uploads.RemoveByID(upload.ID)
+			mirror.abort()
+			abortErr := stream.Abort()
+			upload.fail(errs.Combine(err, abortErr))
+			return
+		}
+
+		// the mirror is committed first, so a required mirror can still
+		// fail the upload
+		err = mirror.commit(ctx, metadata)
 		if err != nil {
 			uploads.RemoveByID(upload.ID)
 			abortErr := stream.Abort()
@@ -77,10 +137,15 @@ func (layer *gatewayLayer) NewMultipartUpload(ctx context.Context, bucket, objec
 		err = stream.Commit()
 		uploads.RemoveByID(upload.ID)
 		if err != nil {
+			mirror.remove(ctx)
 			upload.fail(errs.Combine(err, err))
 			return
 		}
 
+		if layer.gateway.indexing() {
+			layer.updateIndex(ctx, project, bucket, object, previous, metadata)
+		}
+
 		upload.complete(minioObjectInfo(bucket, etag, stream.Info()))
 	}()
 
@@ -97,6 +162,16 @@ func (layer *gatewayLayer) PutObjectPart(ctx context.Context, bucket, object, up
 		return minio.PartInfo{}, err
 	}
 
+	maxParts := layer.gateway.config.MaxParts
+	if maxParts > 0 && len(upload.getCompletedParts()) >= maxParts {
+		// the parts are streamed into the object, so the upload can't be
+		// completed anymore
+		err = tooManyParts(len(upload.getCompletedParts())+1, maxParts)
+		uploads.RemoveByID(upload.ID)
+		upload.Stream.Abort(err)
+		return minio.PartInfo{}, err
+	}
+
 	part, err := upload.Stream.AddPart(partID, data.Reader)
 	if err != nil {
 		return minio.PartInfo{}, err
@@ -108,7 +183,7 @@ func (layer *gatewayLayer) PutObjectPart(ctx context.Context, bucket, object, up
 	}
 
 	partInfo := minio.PartInfo{
-		PartNumber:   part.Number,
+		PartNumber:   part.ID,
 		LastModified: time.Now(),
 		ETag:         data.MD5CurrentHexString(),
 		Size:         atomic.LoadInt64(&part.Size),
@@ -144,10 +219,38 @@ func (layer *gatewayLayer) CompleteMultipartUpload(ctx context.Context, bucket,
 	defer mon.Task()(&ctx)(&err)
 
 	uploads := layer.multipart
-	upload, err := uploads.Remove(bucket, object, uploadID)
+	upload, err := uploads.Get(bucket, object, uploadID)
+	if err != nil {
+		// the upload was already completed, aborted or has failed
+		return minio.ObjectInfo{}, minio.InvalidUploadID{Bucket: bucket, Object: object, UploadID: uploadID}
+	}
+
+	maxParts := layer.gateway.config.MaxParts
+	if maxParts > 0 && len(uploadedParts) > maxParts {
+		// the upload is cleaned up instead of attempting to assemble this
+		// many parts
+		err = tooManyParts(len(uploadedParts), maxParts)
+		uploads.RemoveByID(upload.ID)
+		upload.Stream.Abort(err)
+		<-upload.Done
+		return minio.ObjectInfo{}, err
+	}
+
+	// the upload is kept when the parts don't match, so the client can
+	// complete it again with the right parts, as in S3
+	err = upload.checkParts(uploadedParts)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	upload, err = uploads.Remove(bucket, object, uploadID)
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
+	if upload == nil {
+		// only one of concurrent completions gets to complete the upload
+		return minio.ObjectInfo{}, minio.InvalidUploadID{Bucket: bucket, Object: object, UploadID: uploadID}
+	}
 
 	// notify stream that there aren't more parts coming
 	upload.Stream.Close()
@@ -211,28 +314,85 @@ func (layer *gatewayLayer) ListMultipartUploads(ctx context.Context, bucket stri
 	lmi.MaxUploads = maxUploads
 	lmi.IsTruncated = false
 
-	uploads.mu.Lock()
-	defer uploads.mu.Unlock()
+	if delimiter != "" && delimiter != "/" {
+		return lmi, minio.UnsupportedDelimiter{Delimiter: delimiter}
+	}
 
+	var pending []minio.MultipartInfo
+	uploads.mu.RLock()
 	for _, upload := range uploads.pending {
-		// TODO support markers
 		if upload.Bucket == bucket && strings.HasPrefix(upload.Object, prefix) {
-			lmi.Uploads = append(lmi.Uploads, minio.MultipartInfo{
+			pending = append(pending, minio.MultipartInfo{
 				UploadID: upload.ID,
 				Object:   upload.Object,
 			})
+		}
+	}
+	uploads.mu.RUnlock()
 
-			if len(lmi.Uploads) > maxUploads {
-				lmi.Uploads = lmi.Uploads[:maxUploads]
-				lmi.IsTruncated = true
-				break
+	sort.Slice(pending, func(i, k int) bool {
+		if pending[i].Object == pending[k].Object {
+			return pending[i].UploadID < pending[k].UploadID
+		}
+		return pending[i].Object < pending[k].Object
+	})
+
+	for _, upload := range pending {
+		if !afterUploadMarker(upload.Object, upload.UploadID, keyMarker, uploadIDMarker) {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(upload.Object[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := upload.Object[:len(prefix)+i+len(delimiter)]
+				if len(lmi.CommonPrefixes) > 0 && lmi.CommonPrefixes[len(lmi.CommonPrefixes)-1] == commonPrefix {
+					continue
+				}
+				if commonPrefix == keyMarker {
+					// the prefix was already returned on the previous page
+					continue
+				}
+				if len(lmi.Uploads)+len(lmi.CommonPrefixes) >= maxUploads {
+					lmi.IsTruncated = true
+					break
+				}
+				lmi.CommonPrefixes = append(lmi.CommonPrefixes, commonPrefix)
+				lmi.NextKeyMarker = commonPrefix
+				lmi.NextUploadIDMarker = ""
+				continue
 			}
 		}
+
+		if len(lmi.Uploads)+len(lmi.CommonPrefixes) >= maxUploads {
+			lmi.IsTruncated = true
+			break
+		}
+		lmi.Uploads = append(lmi.Uploads, upload)
+		lmi.NextKeyMarker = upload.Object
+		lmi.NextUploadIDMarker = upload.UploadID
+	}
+
+	if !lmi.IsTruncated {
+		lmi.NextKeyMarker = ""
+		lmi.NextUploadIDMarker = ""
 	}
 
 	return lmi, nil
 }
 
+// afterUploadMarker returns whether the upload is listed after the position
+// given by keyMarker and uploadIDMarker. The upload ID marker is only taken
+// into account together with a key marker.
+func afterUploadMarker(object, uploadID, keyMarker, uploadIDMarker string) bool {
+	if keyMarker == "" {
+		return true
+	}
+	if object != keyMarker {
+		return object > keyMarker
+	}
+	return uploadIDMarker != "" && uploadID > uploadIDMarker
+}
+
 // TODO: implement
 // func (layer *gatewayLayer) CopyObjectPart(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, uploadID string, partID int, startOffset int64, length int64, srcInfo minio.ObjectInfo) (info minio.PartInfo, err error) {
 
@@ -287,10 +447,11 @@ func (uploads *MultipartUploads) Get(bucket, object, uploadID string) (*Multipar
 	return upload, nil
 }
 
-// Remove returns and removes a pending upload
+// Remove returns and removes a pending upload. It returns a nil upload if
+// the upload isn't pending anymore, so only one of concurrent calls gets it.
 func (uploads *MultipartUploads) Remove(bucket, object, uploadID string) (*MultipartUpload, error) {
-	uploads.mu.RLock()
-	defer uploads.mu.RUnlock()
+	uploads.mu.Lock()
+	defer uploads.mu.Unlock()
 
 	upload, ok := uploads.pending[uploadID]
 	if !ok {
@@ -308,10 +469,24 @@ func (uploads *MultipartUploads) Remove(bucket, object, uploadID string) (*Multi
 	return upload, nil
 }
 
-// RemoveByID removes pending upload by id
-func (uploads *MultipartUploads) RemoveByID(uploadID string) {
+// PendingIn returns the pending uploads to the bucket
+func (uploads *MultipartUploads) PendingIn(bucket string) []*MultipartUpload {
 	uploads.mu.RLock()
 	defer uploads.mu.RUnlock()
+
+	var pending []*MultipartUpload
+	for _, upload := range uploads.pending {
+		if upload.Bucket == bucket {
+			pending = append(pending, upload)
+		}
+	}
+	return pending
+}
+
+// RemoveByID removes pending upload by id
+func (uploads *MultipartUploads) RemoveByID(uploadID string) {
+	uploads.mu.Lock()
+	defer uploads.mu.Unlock()
 	delete(uploads.pending, uploadID)
 }
 
@@ -374,9 +549,61 @@ func (upload *MultipartUpload) complete(info minio.ObjectInfo) {
 	close(upload.Done)
 }
 
+// checkParts returns an error if the parts the client completes the upload
+// with aren't listed in ascending order without duplicates or don't match the
+// uploaded parts. The parts are already streamed into the object in the order
+// of their numbers, so none of them can be left out.
+func (upload *MultipartUpload) checkParts(listed []minio.CompletePart) error {
+	for i := 1; i < len(listed); i++ {
+		if listed[i].PartNumber <= listed[i-1].PartNumber {
+			return miniov6.ErrorResponse{
+				StatusCode: http.StatusBadRequest,
+				Code:       "InvalidPartOrder",
+				Message:    fmt.Sprintf("part %d is listed after part %d, parts must be listed in ascending order without duplicates", listed[i].PartNumber, listed[i-1].PartNumber),
+				RequestID:  "minio",
+			}
+		}
+	}
+
+	completed := upload.sortedParts()
+	for i, part := range listed {
+		if i >= len(completed) || completed[i].PartNumber != part.PartNumber {
+			return minio.InvalidPart{PartNumber: part.PartNumber, GotETag: part.ETag}
+		}
+		if canonicalEtag(completed[i].ETag) != canonicalEtag(part.ETag) {
+			return minio.InvalidPart{PartNumber: part.PartNumber, ExpETag: completed[i].ETag, GotETag: part.ETag}
+		}
+	}
+	if len(completed) > len(listed) {
+		missing := completed[len(listed)]
+		return minio.InvalidPart{PartNumber: missing.PartNumber, ExpETag: missing.ETag}
+	}
+	return nil
+}
+
+// tooManyParts returns the error of uploads with more than maxParts parts.
+func tooManyParts(parts, maxParts int) error {
+	return miniov6.ErrorResponse{
+		StatusCode: http.StatusBadRequest,
+		Code:       "InvalidRequest",
+		Message:    fmt.Sprintf("upload has %d parts, more than the maximum of %d", parts, maxParts),
+		RequestID:  "minio",
+	}
+}
+
+// sortedParts returns the completed parts in the order of their numbers,
+// which is the order they are streamed into the object in.
+func (upload *MultipartUpload) sortedParts() []minio.PartInfo {
+	parts := upload.getCompletedParts()
+	sort.Slice(parts, func(i, k int) bool {
+		return parts[i].PartNumber < parts[k].PartNumber
+	})
+	return parts
+}
+
 func (upload *MultipartUpload) etag() (string, error) {
 	var hashes []byte
-	parts := upload.getCompletedParts()
+	parts := upload.sortedParts()
 	for _, part := range parts {
 		md5, err := hex.DecodeString(canonicalEtag(part.ETag))
 		if err != nil {
@@ -391,6 +618,69 @@ func (upload *MultipartUpload) etag() (string, error) {
 	return hex.EncodeToString(sum[:]) + "-" + strconv.Itoa(len(parts)), nil
 }
 
+// parts returns the numbers and sizes of the completed parts in the format
+// stored in the partsKey metadata, e.g. "1:5242880,2:5242880,3:1024".
+func (upload *MultipartUpload) parts() string {
+	parts := upload.sortedParts()
+
+	encoded := make([]string, 0, len(parts))
+	for _, part := range parts {
+		encoded = append(encoded, strconv.Itoa(part.PartNumber)+":"+strconv.FormatInt(part.Size, 10))
+	}
+	return strings.Join(encoded, ",")
+}
+
+// objectParts returns the parts of an object uploaded with a multipart
+// upload, or nil for other objects.
+func objectParts(object *uplink.Object) []minio.ObjectPartInfo {
+	encoded, ok := object.Custom[partsKey]
+	if !ok || encoded == "" {
+		return nil
+	}
+
+	var parts []minio.ObjectPartInfo
+	for _, part := range strings.Split(encoded, ",") {
+		fields := strings.SplitN(part, ":", 2)
+		if len(fields) != 2 {
+			return nil
+		}
+		number, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil
+		}
+		parts = append(parts, minio.ObjectPartInfo{Number: number, Size: size, ActualSize: size})
+	}
+	return parts
+}
+
+// partRange returns the range of the object to read for the range within
+// the part with the number, or for the whole part if rangeSpec is nil. The
+// returned range is relative to the whole object, so the Content-Range of the
+// response refers to the whole object, as in S3.
+func partRange(parts []minio.ObjectPartInfo, number int, rangeSpec *minio.HTTPRangeSpec) (*minio.HTTPRangeSpec, error) {
+	var start int64
+	for _, part := range parts {
+		if part.Number != number {
+			start += part.Size
+			continue
+		}
+
+		offset, length, err := rangeSpec.GetOffsetLength(part.Size)
+		if err != nil || length <= 0 {
+			return nil, miniov6.ErrInvalidArgument(fmt.Sprintf("range is invalid for part %d of %d bytes", number, part.Size))
+		}
+		return &minio.HTTPRangeSpec{
+			Start: start + offset,
+			End:   start + offset + length - 1,
+		}, nil
+	}
+	return nil, miniov6.ErrInvalidArgument(fmt.Sprintf("part %d doesn't exist", number))
+}
+
 func canonicalEtag(etag string) string {
 	etag = strings.Trim(etag, `"`)
 	p := strings.IndexByte(etag, '-')
@@ -407,6 +697,7 @@ type MultipartStream struct {
 	err         error
 	closed      bool
 	finished    bool
+	gaps        bool
 	nextID      int
 	nextNumber  int
 	currentPart *StreamPart
@@ -469,18 +760,20 @@ func (stream *MultipartStream) Read(data []byte) (n int, err error) {
 	for {
 		// has an error occurred?
 		if stream.err != nil {
+			err = stream.err
 			stream.mu.Unlock()
-			return 0, Error.Wrap(err)
+			return 0, err
 		}
 		// still uploading the current part?
 		if stream.currentPart != nil {
 			break
 		}
-		// do we have the next part?
-		if len(stream.parts) > 0 && stream.nextID == stream.parts[0].ID {
+		// do we have the next part? with gaps allowed, it's the lowest
+		// numbered waiting one and lower numbered parts are rejected later
+		if len(stream.parts) > 0 && (stream.nextID == stream.parts[0].ID || stream.gaps) {
 			stream.currentPart = stream.parts[0]
 			stream.parts = stream.parts[1:]
-			stream.nextID++
+			stream.nextID = stream.currentPart.ID + 1
 			break
 		}
 		// we don't have the next part and are closed, hence we are complete
@@ -0,0 +1,50 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+// keyNamespace confines the object keys of each access key to its own
+// namespace within each bucket. Keys are prefixed with the namespace of the
+// access key the request is signed with on the way in and the prefix is
+// removed on the way out, so clients can't address or list keys outside of
+// it. Requests without an access key, e.g. anonymous requests, are denied.
+//
+// Buckets themselves are shared between namespaces.
+type keyNamespace struct {
+	identityMapping
+}
+
+// prefix returns the key prefix of the namespace of the request served with
+// ctx, if it has one.
+func (keyNamespace) prefix(ctx context.Context) (string, bool) {
+	accessKey := getRequest(ctx).accessKey
+	if accessKey == "" || strings.Contains(accessKey, "/") {
+		return "", false
+	}
+	return accessKey + "/", true
+}
+
+func (ns keyNamespace) check(ctx context.Context, bucket, object string) error {
+	if _, ok := ns.prefix(ctx); !ok {
+		mon.Counter("namespace_access_denied").Inc(1)
+		return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	return nil
+}
+
+func (ns keyNamespace) key(ctx context.Context, object string) string {
+	prefix, _ := ns.prefix(ctx)
+	return prefix + object
+}
+
+func (ns keyNamespace) object(ctx context.Context, key string) string {
+	prefix, _ := ns.prefix(ctx)
+	return strings.TrimPrefix(key, prefix)
+}
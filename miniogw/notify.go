@@ -0,0 +1,196 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/event"
+)
+
+// WebhookConfig configures notifications about object events sent to
+// a webhook.
+type WebhookConfig struct {
+	Endpoint   string        `help:"URL to POST JSON notifications about created and deleted objects to" default:""`
+	QueueSize  int           `help:"maximum number of notifications waiting for delivery, further ones are dropped" default:"1000"`
+	MaxRetries int           `help:"how many times a failed notification delivery is retried" default:"5"`
+	RetryDelay time.Duration `help:"delay before retrying a failed notification delivery, doubled on each retry" default:"1s"`
+	DrainDelay time.Duration `help:"how long closing the gateway waits for the queued notifications to be delivered" default:"10s"`
+}
+
+// notifier delivers object events to the configured webhook in the
+// background. The events are delivered at least once unless the queue is full,
+// all retries fail or the delivery doesn't finish in time when closing.
+type notifier struct {
+	config WebhookConfig
+	client *http.Client
+
+	queue    chan event.Log
+	draining chan struct{}
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// newNotifier starts delivering events to the webhook of config.
+func newNotifier(config WebhookConfig) *notifier {
+	notifier := &notifier{
+		config:   config,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		queue:    make(chan event.Log, config.QueueSize),
+		draining: make(chan struct{}),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go notifier.run()
+	return notifier
+}
+
+// notify queues an event about object without blocking.
+func (notifier *notifier) notify(name event.Name, object minio.ObjectInfo) {
+	key := url.QueryEscape(object.Name)
+	entry := event.Log{
+		EventName: name,
+		Key:       object.Bucket + "/" + key,
+		Records: []event.Event{{
+			EventVersion: "2.0",
+			EventSource:  "storj:s3",
+			EventTime:    time.Now().UTC().Format(event.AMZTimeFormat),
+			EventName:    name,
+			S3: event.Metadata{
+				SchemaVersion: "1.0",
+				Bucket: event.Bucket{
+					Name: object.Bucket,
+					ARN:  "arn:aws:s3:::" + object.Bucket,
+				},
+				Object: event.Object{
+					Key:         key,
+					Size:        object.Size,
+					ETag:        object.ETag,
+					ContentType: object.ContentType,
+					Sequencer:   object.ModTime.UTC().Format("20060102150405.000000000"),
+				},
+			},
+		}},
+	}
+
+	select {
+	case notifier.queue <- entry:
+	default:
+		mon.Counter("webhook_dropped").Inc(1)
+	}
+}
+
+// close delivers the events that are still queued and stops the delivery.
+// The events not delivered within the drain delay are dropped.
+func (notifier *notifier) close() {
+	close(notifier.draining)
+	select {
+	case <-notifier.finished:
+	case <-time.After(notifier.config.DrainDelay):
+		close(notifier.done)
+		<-notifier.finished
+	}
+}
+
+func (notifier *notifier) run() {
+	defer close(notifier.finished)
+	for {
+		select {
+		case entry := <-notifier.queue:
+			notifier.deliver(entry)
+		case <-notifier.draining:
+			notifier.drain()
+			return
+		}
+	}
+}
+
+// drain delivers the queued events until the queue is empty or the delivery
+// is stopped.
+func (notifier *notifier) drain() {
+	for {
+		select {
+		case <-notifier.done:
+			return
+		default:
+		}
+
+		select {
+		case entry := <-notifier.queue:
+			notifier.deliver(entry)
+		default:
+			return
+		}
+	}
+}
+
+// deliver posts entry to the webhook, retrying with backoff on failures.
+func (notifier *notifier) deliver(entry event.Log) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		mon.Counter("webhook_failed").Inc(1)
+		return
+	}
+
+	delay := notifier.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err = notifier.post(data)
+		if err == nil {
+			return
+		}
+		if attempt >= notifier.config.MaxRetries {
+			mon.Counter("webhook_failed").Inc(1)
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-notifier.done:
+			return
+		}
+	}
+}
+
+func (notifier *notifier) post(data []byte) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-notifier.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, notifier.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := notifier.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return Error.New("webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// notify sends a notification about the event on object, if configured.
+func (gateway *Gateway) notify(name event.Name, object minio.ObjectInfo) {
+	if gateway.notifier != nil {
+		gateway.notifier.notify(name, object)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+
+	"storj.io/uplink"
+)
+
+const (
+	// aliasHeader is the metadata header clients upload alias objects with.
+	// Its value is the key of the target object in the same bucket.
+	aliasHeader = "X-Amz-Meta-Alias-Target"
+
+	// aliasKey is the custom metadata key of alias objects, which holds the
+	// key of the target object.
+	aliasKey = "s3:alias"
+)
+
+// markAlias moves the target of an alias object uploaded by the client from
+// the metadata header to the reserved metadata key, if object aliases are
+// enabled.
+func (gateway *Gateway) markAlias(metadata map[string]string) {
+	if !gateway.config.ObjectAliases {
+		return
+	}
+	for key, value := range metadata {
+		if strings.EqualFold(key, aliasHeader) {
+			delete(metadata, key)
+			if value != "" {
+				metadata[aliasKey] = value
+			}
+		}
+	}
+}
+
+// resolveAlias returns the key of the target object if the object is an
+// alias object, and the key of the object otherwise. The target is the
+// stored key, as the key mapping layers map it on upload, see aliasTarget.
+//
+// Only one level of aliases is resolved, so an alias to another alias object
+// serves the other alias object itself and loops are impossible.
+func (layer *gatewayLayer) resolveAlias(ctx context.Context, project *uplink.Project, bucketName, objectPath string) (_ string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if !layer.gateway.config.ObjectAliases {
+		return objectPath, nil
+	}
+
+	object, err := project.StatObject(ctx, bucketName, objectPath)
+	if err != nil {
+		return "", err
+	}
+
+	if target, ok := object.Custom[aliasKey]; ok {
+		// the targets are mapped like the keys of the clients on upload, but
+		// aliases may have been uploaded before the keys were reserved
+		if layer.gateway.config.DenyReservedKeys {
+			reserved := &reservedKeys{prefixes: layer.gateway.reservedPrefixes(), dedup: layer.gateway.config.DedupBucket}
+			if err := reserved.check(ctx, bucketName, target); err != nil {
+				return "", minio.PrefixAccessDenied{Bucket: bucketName, Object: objectPath}
+			}
+		}
+		mon.Counter("object_alias_resolved").Inc(1)
+		return target, nil
+	}
+	return objectPath, nil
+}
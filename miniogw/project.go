@@ -0,0 +1,124 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// errProjectReopening is returned for requests that arrive while the project
+// is being reopened. Minio responds to it with a retryable SlowDown error
+// including a Retry-After header.
+var errProjectReopening = minio.InsufficientReadQuorum{}
+
+// reopenRetryAfter is how long clients are asked to wait before retrying
+// requests rejected while the project is reopened, which usually takes only
+// as long as opening a project. Minio asks for 120 seconds otherwise.
+const reopenRetryAfter = time.Second
+
+// ReopenProjects reopens the projects of all layers created by the gateway,
+// e.g. to pick up a changed network configuration. Requests arriving while
+// a project is reopened are rejected with a retryable error.
+func (gateway *Gateway) ReopenProjects(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	gateway.mu.Lock()
+	layers := append([]*gatewayLayer{}, gateway.layers...)
+	gateway.mu.Unlock()
+
+	for _, layer := range layers {
+		err = errs.Combine(err, layer.reopenProject(ctx))
+	}
+
+	// the write permission may have changed with the time limits of the
+	// access grant
+	gateway.probeWrites(ctx)
+	return err
+}
+
+// openProject returns the project to serve a request with and the function
+// to release it, which has to be called once the request doesn't use the
+// project anymore, so a reopen doesn't close it meanwhile. While the project
+// is being reopened, it waits up to the configured time for the reopen to
+// finish before giving up with a retryable error.
+func (layer *gatewayLayer) openProject(ctx context.Context) (_ *uplink.Project, release func(), _ error) {
+	layer.projectMu.Lock()
+	project, users, reopened := layer.project, layer.projectUsers, layer.reopened
+	if reopened == nil {
+		users.Add(1)
+	}
+	layer.projectMu.Unlock()
+
+	if reopened == nil {
+		var once sync.Once
+		return project, func() { once.Do(users.Done) }, nil
+	}
+
+	getRequest(ctx).retryAfter = reopenRetryAfter
+
+	timer := time.NewTimer(layer.gateway.config.ReopenWait)
+	defer timer.Stop()
+
+	select {
+	case <-reopened:
+		return layer.openProject(ctx)
+	case <-timer.C:
+		return nil, nil, errProjectReopening
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// reopenProject replaces the project of the layer with a newly opened one.
+// The old project is kept when opening the new one fails, otherwise it's
+// closed in the background once the requests still using it are done, as
+// e.g. pending multipart uploads may use it for a long time.
+func (layer *gatewayLayer) reopenProject(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	layer.projectMu.Lock()
+	if layer.reopened != nil {
+		layer.projectMu.Unlock()
+		return Error.New("project is already being reopened")
+	}
+	reopened := make(chan struct{})
+	layer.reopened = reopened
+	layer.projectMu.Unlock()
+
+	project, err := layer.gateway.uplinkConfig.OpenProject(ctx, layer.gateway.access)
+
+	layer.projectMu.Lock()
+	old, oldUsers := layer.project, layer.projectUsers
+	if err == nil {
+		layer.project = project
+		layer.projectUsers = new(sync.WaitGroup)
+		layer.buckets.clear()
+		if layer.slowStart != nil {
+			layer.slowStart.restart()
+		}
+	}
+	layer.reopened = nil
+	layer.projectMu.Unlock()
+
+	close(reopened)
+
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	go func() {
+		oldUsers.Wait()
+		if err := old.Close(); err != nil {
+			mon.Counter("project_close_failed").Inc(1)
+		}
+	}()
+	return nil
+}
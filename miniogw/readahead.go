@@ -0,0 +1,126 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+
+	"storj.io/common/memory"
+)
+
+// readaheadChunkSize is the size of the chunks the content is prefetched in.
+const readaheadChunkSize = 64 * memory.KiB
+
+// ReadaheadConfig configures prefetching the content of downloads while the
+// client consumes the already downloaded content.
+type ReadaheadConfig struct {
+	Enabled bool        `help:"prefetch the content of downloads ahead of the client, improving the throughput of sequential downloads on high latency links" default:"false"`
+	Size    memory.Size `help:"how much content is prefetched ahead of the client per download" default:"4MiB"`
+}
+
+// enabled reports whether the content of downloads is prefetched.
+func (config ReadaheadConfig) enabled() bool {
+	return config.Enabled && config.Size > 0
+}
+
+// readaheadReader prefetches the content of a reader in a goroutine.
+type readaheadReader struct {
+	ctx    context.Context
+	cancel func()
+	chunks chan readaheadChunk
+	// done is closed when the prefetching goroutine exits.
+	done chan struct{}
+
+	current []byte
+	err     error
+}
+
+// readaheadChunk is a prefetched chunk of content and the error the read of
+// it ended with.
+type readaheadChunk struct {
+	data []byte
+	err  error
+}
+
+// NewReadaheadReader returns a reader of the content of reader, which
+// prefetches up to size bytes of it ahead of the consumer. Prefetching stops
+// when the returned reader is closed or ctx is canceled, e.g. because the
+// client disconnected. Closing the returned reader doesn't close reader, but
+// waits until it's not read anymore, so it can be closed afterwards.
+func NewReadaheadReader(ctx context.Context, reader io.Reader, size memory.Size) io.ReadCloser {
+	chunkSize := readaheadChunkSize
+	if size < chunkSize {
+		chunkSize = size
+	}
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	readahead := &readaheadReader{
+		ctx:    ctx,
+		cancel: cancel,
+		chunks: make(chan readaheadChunk, size/chunkSize),
+		done:   make(chan struct{}),
+	}
+	go readahead.prefetch(reader, chunkSize.Int())
+	return readahead
+}
+
+// prefetch reads the content in chunks until it ends or the reader is
+// canceled.
+func (readahead *readaheadReader) prefetch(reader io.Reader, chunkSize int) {
+	defer close(readahead.done)
+	defer close(readahead.chunks)
+
+	for {
+		data := make([]byte, chunkSize)
+		n, err := io.ReadFull(reader, data)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+
+		select {
+		case readahead.chunks <- readaheadChunk{data: data[:n], err: err}:
+		case <-readahead.ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read implements io.Reader.
+func (readahead *readaheadReader) Read(p []byte) (n int, err error) {
+	for len(readahead.current) == 0 {
+		if readahead.err != nil {
+			return 0, readahead.err
+		}
+
+		// the prefetching may be blocked on the reader, so don't wait for it
+		// when canceled
+		select {
+		case chunk, ok := <-readahead.chunks:
+			if !ok {
+				return 0, readahead.ctx.Err()
+			}
+			readahead.current, readahead.err = chunk.data, chunk.err
+		case <-readahead.ctx.Done():
+			readahead.err = readahead.ctx.Err()
+		}
+	}
+
+	n = copy(p, readahead.current)
+	readahead.current = readahead.current[n:]
+	return n, nil
+}
+
+// Close stops prefetching and waits for the read in progress to finish.
+func (readahead *readaheadReader) Close() error {
+	readahead.cancel()
+	<-readahead.done
+	return nil
+}
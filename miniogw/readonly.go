@@ -0,0 +1,206 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	minio "github.com/minio/minio/cmd"
+	"github.com/zeebo/errs"
+
+	"storj.io/common/errs2"
+	"storj.io/common/macaroon"
+	"storj.io/common/pb"
+	"storj.io/common/rpc/rpcstatus"
+	"storj.io/uplink"
+)
+
+// allowsWrites reports whether the API key of the access grant allows
+// uploads. uplink doesn't expose the permissions of access grants, so the API
+// key is taken from the serialized access grant.
+func allowsWrites(ctx context.Context, access *uplink.Access) (_ bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	serialized, err := access.Serialize()
+	if err != nil {
+		return false, err
+	}
+
+	data, _, err := base58.CheckDecode(serialized)
+	if err != nil {
+		return false, err
+	}
+
+	scope := new(pb.Scope)
+	if err := pb.Unmarshal(data, scope); err != nil {
+		return false, err
+	}
+
+	apiKey, err := macaroon.ParseRawAPIKey(scope.ApiKey)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = apiKey.GetAllowedBuckets(ctx, macaroon.Action{
+		Op:   macaroon.ActionWrite,
+		Time: time.Now(),
+	})
+	if macaroon.ErrUnauthorized.Has(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ReadOnly reports whether the access grant of the gateway doesn't allow
+// writes, in which case writes are denied and only reads are served.
+func (gateway *Gateway) ReadOnly() bool {
+	return atomic.LoadInt32(&gateway.readOnly) != 0
+}
+
+// probeWrites checks again whether the access grant of the gateway allows
+// writes, as time limits of the API key may have started or ended to allow
+// them, and reports whether it does.
+func (gateway *Gateway) probeWrites(ctx context.Context) bool {
+	writable, err := allowsWrites(ctx, gateway.access)
+	if err != nil {
+		// serve writes as usual, they fail if they aren't allowed
+		mon.Counter("read_only_detection_failed").Inc(1)
+		writable = true
+	}
+
+	if writable {
+		atomic.StoreInt32(&gateway.readOnly, 0)
+		mon.IntVal("read_only").Observe(0)
+	} else {
+		atomic.StoreInt32(&gateway.readOnly, 1)
+		mon.IntVal("read_only").Observe(1)
+	}
+	return writable
+}
+
+// layerReadOnly denies all writes with AccessDenied while the gateway is
+// read-only and serves reads with the wrapped layer. Denied writes probe the
+// access grant again, as do writes failing with a permission error, so the
+// gateway follows changes of the write permission.
+type layerReadOnly struct {
+	minio.ObjectLayer
+	gateway *Gateway
+}
+
+// denied reports whether writes are denied.
+func (layer *layerReadOnly) denied(ctx context.Context) bool {
+	return layer.gateway.ReadOnly() && !layer.gateway.probeWrites(ctx)
+}
+
+// failed probes the access grant again if err shows it doesn't allow writes.
+func (layer *layerReadOnly) failed(ctx context.Context, err error) {
+	if errs2.IsRPC(err, rpcstatus.PermissionDenied) || errs2.IsRPC(err, rpcstatus.Unauthenticated) {
+		layer.gateway.probeWrites(ctx)
+	}
+}
+
+func (layer *layerReadOnly) MakeBucketWithLocation(ctx context.Context, bucket string, location string) (err error) {
+	if layer.denied(ctx) {
+		return minio.PrefixAccessDenied{Bucket: bucket}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.MakeBucketWithLocation(ctx, bucket, location)
+}
+
+func (layer *layerReadOnly) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) (err error) {
+	if layer.denied(ctx) {
+		return minio.PrefixAccessDenied{Bucket: bucket}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.DeleteBucket(ctx, bucket, forceDelete)
+}
+
+func (layer *layerReadOnly) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if layer.denied(ctx) {
+		return minio.ObjectInfo{}, minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+func (layer *layerReadOnly) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if layer.denied(ctx) {
+		return minio.ObjectInfo{}, minio.PrefixAccessDenied{Bucket: destBucket, Object: destObject}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+}
+
+func (layer *layerReadOnly) DeleteObject(ctx context.Context, bucket, object string) (err error) {
+	if layer.denied(ctx) {
+		return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.DeleteObject(ctx, bucket, object)
+}
+
+func (layer *layerReadOnly) DeleteObjects(ctx context.Context, bucket string, objects []string) (_ []error, err error) {
+	if layer.denied(ctx) {
+		errors := make([]error, len(objects))
+		for i, object := range objects {
+			errors[i] = minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+		}
+		return errors, nil
+	}
+
+	errors, err := layer.ObjectLayer.DeleteObjects(ctx, bucket, objects)
+	layer.failed(ctx, errs.Combine(append(errors, err)...))
+	return errors, err
+}
+
+func (layer *layerReadOnly) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	if layer.denied(ctx) {
+		return "", minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.NewMultipartUpload(ctx, bucket, object, opts)
+}
+
+func (layer *layerReadOnly) CopyObjectPart(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, uploadID string, partID int, startOffset int64, length int64, srcInfo minio.ObjectInfo, srcOpts, dstOpts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	if layer.denied(ctx) {
+		return minio.PartInfo{}, minio.PrefixAccessDenied{Bucket: destBucket, Object: destObject}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.CopyObjectPart(ctx, srcBucket, srcObject, destBucket, destObject, uploadID, partID, startOffset, length, srcInfo, srcOpts, dstOpts)
+}
+
+func (layer *layerReadOnly) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	if layer.denied(ctx) {
+		return minio.PartInfo{}, minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.PutObjectPart(ctx, bucket, object, uploadID, partID, data, opts)
+}
+
+func (layer *layerReadOnly) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if layer.denied(ctx) {
+		return minio.ObjectInfo{}, minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.CompleteMultipartUpload(ctx, bucket, object, uploadID, uploadedParts, opts)
+}
+
+func (layer *layerReadOnly) PutObjectTag(ctx context.Context, bucket, object string, tags string) (err error) {
+	if layer.denied(ctx) {
+		return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.PutObjectTag(ctx, bucket, object, tags)
+}
+
+func (layer *layerReadOnly) DeleteObjectTag(ctx context.Context, bucket, object string) (err error) {
+	if layer.denied(ctx) {
+		return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+	defer func() { layer.failed(ctx, err) }()
+	return layer.ObjectLayer.DeleteObjectTag(ctx, bucket, object)
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// replacePrefix is the key prefix of the temporary objects of metadata
+// replacements, which aren't listed.
+const replacePrefix = ".gateway-replace/"
+
+// isReplaceKey reports whether key is a temporary object of a metadata
+// replacement.
+func isReplaceKey(key string) bool {
+	return strings.HasPrefix(key, replacePrefix)
+}
+
+// replaceMetadata replaces the metadata of the object by the metadata of a
+// metadata-only copy, keeping its content and ETag.
+//
+// Uplink can't update the metadata of objects, and uploading the object again
+// deletes it before its content could be read, so the stored content is
+// copied to a temporary object first and then back with the new metadata.
+// The content isn't decoded, as it's stored under the same key again. If
+// copying it back fails, the temporary object is kept to recover the content.
+func (layer *gatewayLayer) replaceMetadata(ctx context.Context, project *uplink.Project, bucketName, objectPath string, srcInfo minio.ObjectInfo) (objInfo minio.ObjectInfo, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	object, err := project.StatObject(ctx, bucketName, objectPath)
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	metadata := make(map[string]string, len(srcInfo.UserDefined))
+	for k, v := range srcInfo.UserDefined {
+		metadata[k] = v
+	}
+	if srcInfo.UserDefined == nil {
+		for k, v := range object.Custom {
+			metadata[k] = v
+		}
+	}
+	delete(metadata, expirationHeader)
+	// the keys describing the stored content are kept, replacing metadata
+	// doesn't carry them
+	for _, key := range []string{"s3:etag", partsKey, dedupKey, dedupSizeKey, transformedKey} {
+		delete(metadata, key)
+		if value, ok := object.Custom[key]; ok {
+			metadata[key] = value
+		}
+	}
+
+	temporary, err := temporaryKey(replacePrefix)
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	stored, err := copyStored(ctx, project, object, bucketName, temporary, object.Custom)
+	if err != nil {
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	replaced, err := copyStored(ctx, project, stored, bucketName, objectPath, metadata)
+	if err != nil {
+		mon.Counter("replace_metadata_failed").Inc(1)
+		return minio.ObjectInfo{}, convertError(err, bucketName, objectPath)
+	}
+
+	_, err = project.DeleteObject(ctx, bucketName, temporary)
+	if err != nil {
+		// the metadata is replaced already, only the temporary object is
+		// left behind
+		mon.Counter("replace_cleanup_failed").Inc(1)
+	}
+
+	etag := srcInfo.ETag
+	if etag == "" && objectSize(replaced) == 0 {
+		etag = emptyETag
+	}
+	return minioObjectInfo(bucketName, etag, replaced), nil
+}
+
+// temporaryKey returns a random key under prefix for a temporary object.
+func temporaryKey(prefix string) (string, error) {
+	var suffix [16]byte
+	_, err := rand.Read(suffix[:])
+	if err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(suffix[:]), nil
+}
+
+// copyStored copies the stored content of the object to objectPath with the
+// given metadata, without decoding it or following references to
+// deduplicated content.
+func copyStored(ctx context.Context, project *uplink.Project, object *uplink.Object, bucketName, objectPath string, metadata map[string]string) (_ *uplink.Object, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	// the content of zero-byte objects isn't downloaded, as there is nothing
+	// to copy
+	var source io.Reader = bytes.NewReader(nil)
+	if object.System.ContentLength > 0 {
+		download, err := project.DownloadObject(ctx, bucketName, object.Key, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { err = errs.Combine(err, download.Close()) }()
+		source = download
+	}
+
+	upload, err := project.UploadObject(ctx, bucketName, objectPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(upload, source)
+	if err != nil {
+		return nil, errs.Combine(err, upload.Abort())
+	}
+
+	err = upload.SetCustomMetadata(ctx, metadata)
+	if err != nil {
+		return nil, errs.Combine(err, upload.Abort())
+	}
+
+	err = upload.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return upload.Info(), nil
+}
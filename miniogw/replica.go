@@ -0,0 +1,165 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// ReplicaConfig configures reading objects from a replica project, which has
+// to be kept in sync with the primary project, e.g. by mirroring uploads.
+type ReplicaConfig struct {
+	Access    string `help:"access grant of a replica project to read objects from when reads from the primary project fail, reading from the replica is disabled if empty" default:""`
+	Preferred bool   `help:"read from the replica project first and fall back to the primary project" default:"false"`
+}
+
+// newReplicaLayer returns a layer reading objects from the replica project,
+// or nil if reading from a replica is disabled.
+//
+// The layer isn't reopened with the primary projects.
+func (gateway *Gateway) newReplicaLayer(ctx context.Context) (_ *gatewayLayer, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if gateway.config.Replica.Access == "" {
+		return nil, nil
+	}
+
+	access, err := uplink.ParseAccess(gateway.config.Replica.Access)
+	if err != nil {
+		return nil, err
+	}
+	project, err := gateway.uplinkConfig.OpenProject(ctx, access)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewayLayer{
+		gateway:      gateway,
+		project:      project,
+		projectUsers: new(sync.WaitGroup),
+		multipart:    NewMultipartUploads(),
+	}, nil
+}
+
+// layerReplica serves reads from the primary layer and fails over to the
+// replica layer, or the other way around if the replica is preferred.
+// All other operations are served by the primary layer only.
+type layerReplica struct {
+	minio.ObjectLayer
+	replica   minio.ObjectLayer
+	preferred bool
+}
+
+// read reads with the preferred layer first and with the other one if the
+// read fails with an error other than a minio one, e.g. because the satellite
+// is unavailable. Minio errors, like ObjectNotFound, are final.
+func (replica *layerReplica) read(read func(layer minio.ObjectLayer) error) error {
+	first, second := replica.ObjectLayer, replica.replica
+	if replica.preferred {
+		first, second = second, first
+	}
+
+	err := read(first)
+	if err == nil || minioError(err) {
+		return err
+	}
+
+	mon.Counter("replica_failover").Inc(1)
+	return read(second)
+}
+
+func (replica *layerReplica) Shutdown(ctx context.Context) error {
+	return errs.Combine(replica.ObjectLayer.Shutdown(ctx), replica.replica.Shutdown(ctx))
+}
+
+func (replica *layerReplica) GetBucketInfo(ctx context.Context, bucket string) (bucketInfo minio.BucketInfo, err error) {
+	err = replica.read(func(layer minio.ObjectLayer) (err error) {
+		bucketInfo, err = layer.GetBucketInfo(ctx, bucket)
+		return err
+	})
+	return bucketInfo, err
+}
+
+func (replica *layerReplica) ListBuckets(ctx context.Context) (buckets []minio.BucketInfo, err error) {
+	err = replica.read(func(layer minio.ObjectLayer) (err error) {
+		buckets, err = layer.ListBuckets(ctx)
+		return err
+	})
+	return buckets, err
+}
+
+func (replica *layerReplica) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
+	err = replica.read(func(layer minio.ObjectLayer) (err error) {
+		result, err = layer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+		return err
+	})
+	return result, err
+}
+
+func (replica *layerReplica) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
+	err = replica.read(func(layer minio.ObjectLayer) (err error) {
+		result, err = layer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+		return err
+	})
+	return result, err
+}
+
+func (replica *layerReplica) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
+	err = replica.read(func(layer minio.ObjectLayer) (err error) {
+		// the range may be widened by the first layer
+		var rangeSpec *minio.HTTPRangeSpec
+		if rs != nil {
+			copied := *rs
+			rangeSpec = &copied
+		}
+		reader, err = layer.GetObjectNInfo(ctx, bucket, object, rangeSpec, h, lockType, opts)
+		if err == nil && rs != nil {
+			*rs = *rangeSpec
+		}
+		return err
+	})
+	return reader, err
+}
+
+func (replica *layerReplica) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
+	written := &countingWriter{writer: writer}
+	return replica.read(func(layer minio.ObjectLayer) error {
+		if written.count > 0 {
+			// the content was partially written already, so the
+			// download can't be failed over
+			return err
+		}
+		err = layer.GetObject(ctx, bucket, object, startOffset, length, written, etag, opts)
+		return err
+	})
+}
+
+func (replica *layerReplica) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	err = replica.read(func(layer minio.ObjectLayer) (err error) {
+		objInfo, err = layer.GetObjectInfo(ctx, bucket, object, opts)
+		return err
+	})
+	return objInfo, err
+}
+
+// countingWriter counts the bytes written to the writer.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+// Write implements io.Writer.
+func (counting *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = counting.writer.Write(p)
+	counting.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,232 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "unsafe" // for go:linkname
+
+	minio "github.com/minio/minio/cmd"
+)
+
+// minioHandlers are the HTTP handlers minio wraps its router with when the
+// gateway is started. Minio doesn't offer a way to add handlers to gateways,
+// and the object layer API doesn't carry the request, so the gateway adds its
+// handler to them directly.
+//
+//go:linkname minioHandlers github.com/minio/minio/cmd.globalHandlers
+var minioHandlers []minio.HandlerFunc
+
+// registerRequestHandler makes minio add the request information to the
+// context of all requests. It has to be called before minio is started.
+//
+// It panics if minio's handlers aren't linked, e.g. if an update of minio
+// renamed them, as the gateway would otherwise silently serve requests
+// without their information.
+func registerRequestHandler() {
+	registerRequestHandlerOnce.Do(func() {
+		if len(minioHandlers) == 0 {
+			panic("miniogw: minio's global handlers aren't linked, check the go:linkname of minioHandlers")
+		}
+		minioHandlers = append(minioHandlers, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := WithRequest(r.Context(), r)
+				_, acl := r.URL.Query()["acl"]
+				next.ServeHTTP(&responseWriter{
+					ResponseWriter: w,
+					request:        getRequest(ctx),
+					acl:            acl && r.Method == http.MethodGet,
+				}, r.WithContext(ctx))
+			})
+		})
+	})
+}
+
+var registerRequestHandlerOnce sync.Once
+
+// requestKey is the context key of the request information.
+type requestKey struct{}
+
+// requestInfo is the information about the S3 request an object layer call
+// is serving, which minio doesn't pass to the object layer.
+type requestInfo struct {
+	// accessKey is the access key the request is signed with, it's empty
+	// for anonymous requests and if it's unknown, e.g. for POST policies.
+	accessKey string
+	// anonymous is whether the request isn't authenticated, which minio
+	// allows only if the bucket policy allows the request.
+	anonymous bool
+	// header is the header of the request.
+	header http.Header
+	// retryAfter replaces the time minio asks clients to wait before
+	// retrying the request if it's rejected with a retryable error.
+	retryAfter time.Duration
+	// rangeIgnored is whether the whole object is sent instead of the
+	// requested range, as the If-Range validator doesn't match.
+	rangeIgnored bool
+	// publicRead is whether the object the request is about may be read by
+	// anonymous requests.
+	publicRead bool
+	// emptyDelete is whether the request is a multi-object delete without
+	// any object in its body. Minio drops the objects a request may not
+	// delete before calling the object layer, so the layer can't tell an
+	// empty request from one whose objects are all denied.
+	emptyDelete bool
+}
+
+// WithRequest returns ctx with the information about the request r that the
+// gateway layers need. Minio passes the context of each request to the object
+// layer, and the gateway adds this information to all requests it serves.
+// The body of multi-object delete requests is read ahead and put back into r
+// for minio.
+func WithRequest(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, &requestInfo{
+		accessKey:   requestAccessKey(r),
+		anonymous:   requestAnonymous(r),
+		header:      r.Header,
+		emptyDelete: emptyDeleteRequest(r),
+	})
+}
+
+// getRequest returns the information about the request served with ctx, or
+// the information of an authenticated request without headers when ctx isn't
+// of a request, e.g. for internal calls.
+func getRequest(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{header: http.Header{}}
+}
+
+// requestAnonymous reports whether minio authenticates r as an anonymous
+// request, i.e. it's neither signed nor presigned in any way minio supports.
+func requestAnonymous(r *http.Request) bool {
+	if _, ok := r.Header["Authorization"]; ok {
+		return false
+	}
+	if r.Method == http.MethodPost && strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
+		// POST policy uploads are signed in the form
+		return false
+	}
+	query := r.URL.Query()
+	for _, key := range []string{"X-Amz-Credential", "AWSAccessKeyId", "Action"} {
+		if _, ok := query[key]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// maxDeleteBodySize is the maximum size of the body of multi-object delete
+// requests minio reads.
+const maxDeleteBodySize = 2 * 100000 * 1024
+
+// emptyDeleteRequest reports whether r is a multi-object delete request whose
+// body lists no object. Bodies minio rejects anyway aren't reported.
+func emptyDeleteRequest(r *http.Request) bool {
+	if _, ok := r.URL.Query()["delete"]; !ok || r.Method != http.MethodPost {
+		return false
+	}
+	if r.Body == nil || r.ContentLength <= 0 || r.ContentLength > maxDeleteBodySize {
+		return false
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return false
+	}
+
+	var request minio.DeleteObjectsRequest
+	if err := xml.Unmarshal(body, &request); err != nil {
+		return false
+	}
+	return len(request.Objects) == 0
+}
+
+// requestAccessKey returns the access key r is signed or presigned with.
+func requestAccessKey(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 "):
+		// AWS4-HMAC-SHA256 Credential=<key>/<scope>, SignedHeaders=..., Signature=...
+		for _, field := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ",") {
+			credential := strings.TrimPrefix(strings.TrimSpace(field), "Credential=")
+			if credential != strings.TrimSpace(field) {
+				return strings.SplitN(credential, "/", 2)[0]
+			}
+		}
+		return ""
+	case strings.HasPrefix(authorization, "AWS "):
+		// AWS <key>:<signature>
+		return strings.SplitN(strings.TrimPrefix(authorization, "AWS "), ":", 2)[0]
+	}
+
+	query := r.URL.Query()
+	if credential := query.Get("X-Amz-Credential"); credential != "" {
+		return strings.SplitN(credential, "/", 2)[0]
+	}
+	return query.Get("AWSAccessKeyId")
+}
+
+// responseWriter adjusts the response minio writes to the request.
+type responseWriter struct {
+	http.ResponseWriter
+	request *requestInfo
+	// acl is whether the request gets an ACL.
+	acl bool
+	// replaced is whether the body of minio is replaced.
+	replaced bool
+}
+
+// WriteHeader writes the header of the response.
+func (w *responseWriter) WriteHeader(statusCode int) {
+	header := w.Header()
+	if w.request.retryAfter > 0 && header.Get("Retry-After") != "" {
+		seconds := (w.request.retryAfter + time.Second - 1) / time.Second
+		header.Set("Retry-After", strconv.Itoa(int(seconds)))
+	}
+	if w.request.rangeIgnored && statusCode == http.StatusPartialContent {
+		header.Del("Content-Range")
+		statusCode = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the body of the response. The owner-only ACL minio responds
+// with to object ACL requests is replaced by the public-read ACL for objects
+// anonymous requests may read. Bucket ACL requests don't stat an object, so
+// their response is kept.
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.acl || !w.request.publicRead {
+		return w.ResponseWriter.Write(p)
+	}
+	if !w.replaced {
+		w.replaced = true
+		if err := xml.NewEncoder(w.ResponseWriter).Encode(publicReadACL); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends any buffered data to the client, which some minio handlers
+// require.
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+// reservedPrefixes returns the key prefixes reserved for the internal state
+// of the gateway in each bucket.
+func (gateway *Gateway) reservedPrefixes() []string {
+	var prefixes []string
+	for _, prefix := range gateway.config.ReservedPrefixes {
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if gateway.indexing() {
+		prefixes = append(prefixes, gateway.config.Index.Prefix)
+	}
+	return prefixes
+}
+
+// reservedKeys denies client requests to objects in the reserved prefixes
+// and in the deduplication bucket with AccessDenied. The internal operations
+// of the gateway use the project directly, so they aren't affected.
+type reservedKeys struct {
+	identityMapping
+	prefixes []string
+	dedup    string
+}
+
+// reserved reports whether the key in the bucket is reserved.
+func (reserved *reservedKeys) reserved(bucket, key string) bool {
+	if reserved.dedup != "" && bucket == reserved.dedup {
+		return true
+	}
+	for _, prefix := range reserved.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns AccessDenied if the key in the bucket is reserved.
+func (reserved *reservedKeys) check(ctx context.Context, bucket, key string) error {
+	if reserved.reserved(bucket, key) {
+		mon.Counter("reserved_access_denied").Inc(1)
+		return minio.PrefixAccessDenied{Bucket: bucket, Object: key}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+// KeyRewrite rewrites the object keys starting with Old to start with New
+// instead, e.g. to serve objects migrated from a system with a different key
+// scheme by the keys clients know them by. Both prefixes must end with "/",
+// and no prefix of the rewrites may be a prefix of another.
+type KeyRewrite struct {
+	Old string
+	New string
+}
+
+// validateKeyRewrites returns an error if the listings of the rewritten keys
+// can't be represented.
+func validateKeyRewrites(rewrites []KeyRewrite) error {
+	var prefixes []string
+	for _, r := range rewrites {
+		if !strings.HasSuffix(r.Old, "/") || !strings.HasSuffix(r.New, "/") {
+			return Error.New("key rewrite prefixes must end with \"/\": %q=%q", r.Old, r.New)
+		}
+		prefixes = append(prefixes, r.Old, r.New)
+	}
+	for i, prefix := range prefixes {
+		for k, other := range prefixes {
+			if i != k && strings.HasPrefix(other, prefix) {
+				return Error.New("key rewrite prefixes overlap: %q and %q", prefix, other)
+			}
+		}
+	}
+	return nil
+}
+
+// keyRewrites rewrites the object keys clients use to the stored keys on the
+// way in, and the stored keys back on the way out.
+//
+// The stored prefixes should only be reached through the rewrites, as their
+// keys are listed as rewritten. Keys stored under the old prefixes can't be
+// reached at all and aren't listed.
+type keyRewrites struct {
+	identityMapping
+	rewrites []KeyRewrite
+}
+
+// key returns the stored key of object.
+func (rewrite *keyRewrites) key(ctx context.Context, object string) string {
+	for _, r := range rewrite.rewrites {
+		if strings.HasPrefix(object, r.Old) {
+			mon.Counter("key_rewritten").Inc(1)
+			return r.New + strings.TrimPrefix(object, r.Old)
+		}
+	}
+	return object
+}
+
+// object returns the key of object as seen by the client.
+func (rewrite *keyRewrites) object(ctx context.Context, key string) string {
+	for _, r := range rewrite.rewrites {
+		if strings.HasPrefix(key, r.New) {
+			return r.Old + strings.TrimPrefix(key, r.New)
+		}
+	}
+	return key
+}
+
+// rewritten reports whether the key or prefix is under a prefix of the
+// rewrites, so it isn't listed as it's stored.
+func (rewrite *keyRewrites) rewritten(key string) bool {
+	for _, r := range rewrite.rewrites {
+		if strings.HasPrefix(key, r.Old) || strings.HasPrefix(key, r.New) {
+			return true
+		}
+	}
+	return false
+}
+
+// layerRewrite rewrites the keys with keyRewrites, and lists the keys as
+// clients see them: sorted by the old keys, with the common prefixes of the
+// old keys, and including the rewritten keys in listings of prefixes of the
+// old prefixes.
+type layerRewrite struct {
+	*layerKeys
+	rewrites *keyRewrites
+}
+
+// listEntry is an object or a common prefix of a listing.
+type listEntry struct {
+	key    string
+	prefix bool
+	info   minio.ObjectInfo
+}
+
+// list returns the entries of the listing after marker as clients see them,
+// which are merged from the listings of the stored keys that aren't
+// rewritten and those of each rewrite.
+func (rewrite *layerRewrite) list(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (entries []listEntry, more bool, err error) {
+	var all []listEntry
+	add := func(client, stored string, hide bool) error {
+		listed, truncated, err := rewrite.listStored(ctx, bucket, client, stored, marker, delimiter, maxKeys, hide)
+		all = append(all, listed...)
+		more = more || truncated
+		return err
+	}
+
+	if !rewrite.rewrites.rewritten(prefix) {
+		if err := add(prefix, prefix, true); err != nil {
+			return nil, false, err
+		}
+	}
+	for _, r := range rewrite.rewrites.rewrites {
+		switch {
+		case strings.HasPrefix(prefix, r.Old):
+			err = add(prefix, r.New+strings.TrimPrefix(prefix, r.Old), false)
+		case strings.HasPrefix(r.Old, prefix):
+			// the rewritten keys are in a common prefix of the listing
+			if i := strings.Index(r.Old[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+				common := r.Old[:len(prefix)+i+len(delimiter)]
+				if common > marker {
+					all = append(all, listEntry{key: common, prefix: true})
+				}
+				continue
+			}
+			err = add(r.Old, r.New, false)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	sort.Slice(all, func(i, k int) bool { return all[i].key < all[k].key })
+	for _, entry := range all {
+		if len(entries) > 0 && entries[len(entries)-1].key == entry.key {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if maxKeys > 0 && len(entries) > maxKeys {
+		entries, more = entries[:maxKeys], true
+	}
+	return entries, more, nil
+}
+
+// listStored lists the stored keys under the stored prefix after marker as
+// the keys under the client prefix, until maxKeys entries are listed. Keys
+// that are rewritten are skipped if hide is set.
+func (rewrite *layerRewrite) listStored(ctx context.Context, bucket, client, stored, marker, delimiter string, maxKeys int, hide bool) (entries []listEntry, more bool, err error) {
+	storedMarker := ""
+	switch {
+	case strings.HasPrefix(marker, client):
+		storedMarker = stored + strings.TrimPrefix(marker, client)
+	case marker > client:
+		// all keys under the prefix are before the marker
+		return nil, false, nil
+	}
+
+	for {
+		result, err := rewrite.layerKeys.ObjectLayer.ListObjects(ctx, bucket, stored, storedMarker, delimiter, maxKeys)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, info := range result.Objects {
+			info.Name = client + strings.TrimPrefix(info.Name, stored)
+			if !hide || !rewrite.rewrites.rewritten(info.Name) {
+				entries = append(entries, listEntry{key: info.Name, info: info})
+			}
+		}
+		for _, prefix := range result.Prefixes {
+			prefix = client + strings.TrimPrefix(prefix, stored)
+			if !hide || !rewrite.rewrites.rewritten(prefix) {
+				entries = append(entries, listEntry{key: prefix, prefix: true})
+			}
+		}
+		if !result.IsTruncated || (maxKeys > 0 && len(entries) >= maxKeys) {
+			return entries, result.IsTruncated, nil
+		}
+		storedMarker = result.NextMarker
+	}
+}
+
+func (rewrite *layerRewrite) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
+	entries, more, err := rewrite.list(ctx, bucket, prefix, marker, delimiter, maxKeys)
+	if err != nil {
+		return minio.ListObjectsInfo{}, rewrite.error(ctx, bucket, err)
+	}
+
+	result.IsTruncated = more
+	for _, entry := range entries {
+		if entry.prefix {
+			result.Prefixes = append(result.Prefixes, entry.key)
+		} else {
+			result.Objects = append(result.Objects, entry.info)
+		}
+	}
+	if more && len(entries) > 0 {
+		result.NextMarker = entries[len(entries)-1].key
+	}
+	return result, nil
+}
+
+func (rewrite *layerRewrite) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
+	marker := continuationToken
+	if marker == "" {
+		marker = startAfter
+	}
+
+	list, err := rewrite.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+	if err != nil {
+		return minio.ListObjectsV2Info{ContinuationToken: continuationToken}, err
+	}
+	return minio.ListObjectsV2Info{
+		IsTruncated:           list.IsTruncated,
+		ContinuationToken:     continuationToken,
+		NextContinuationToken: list.NextMarker,
+		Objects:               list.Objects,
+		Prefixes:              list.Prefixes,
+	}, nil
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+// SlowStartConfig configures ramping up the number of concurrent object
+// requests after the project is opened or reopened, so a burst of requests
+// doesn't overwhelm the newly opened connections.
+type SlowStartConfig struct {
+	Duration time.Duration `help:"how long the number of concurrent object requests is ramped up after the project is (re)opened, disabled if zero" default:"0s"`
+	Initial  int           `help:"number of concurrent object requests allowed right after the project is (re)opened" default:"4"`
+	Limit    int           `help:"number of concurrent object requests allowed at the end of the ramp, after which they are unlimited" default:"64"`
+}
+
+// slowStart ramps up the number of concurrent requests linearly from the
+// initial to the final limit after it's restarted. Once the ramp is over, the
+// requests are unlimited.
+type slowStart struct {
+	config SlowStartConfig
+
+	mu      sync.Mutex
+	start   time.Time
+	active  int
+	changed chan struct{}
+}
+
+// newSlowStart returns a slow start starting now.
+func newSlowStart(config SlowStartConfig) *slowStart {
+	if config.Initial < 1 {
+		config.Initial = 1
+	}
+	if config.Limit < config.Initial {
+		config.Limit = config.Initial
+	}
+	return &slowStart{
+		config:  config,
+		start:   time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// restart starts the ramp again, e.g. after the project is reopened.
+func (slow *slowStart) restart() {
+	slow.mu.Lock()
+	defer slow.mu.Unlock()
+
+	slow.start = time.Now()
+}
+
+// allowed returns the number of concurrent requests allowed at now. It has to
+// be called with the lock held.
+func (slow *slowStart) allowed(now time.Time) int {
+	elapsed := now.Sub(slow.start)
+	if elapsed >= slow.config.Duration {
+		return math.MaxInt32
+	}
+	ramp := int64(slow.config.Limit - slow.config.Initial)
+	return slow.config.Initial + int(ramp*int64(elapsed)/int64(slow.config.Duration))
+}
+
+// acquire waits until another request is allowed. The returned function has
+// to be called once the request is done.
+func (slow *slowStart) acquire(ctx context.Context) (release func(), err error) {
+	// the allowed requests grow by one in each step of the ramp
+	step := slow.config.Duration / time.Duration(slow.config.Limit-slow.config.Initial+1)
+
+	waited := false
+	for {
+		slow.mu.Lock()
+		if slow.active < slow.allowed(time.Now()) {
+			slow.active++
+			slow.mu.Unlock()
+			return slow.release, nil
+		}
+		changed := slow.changed
+		slow.mu.Unlock()
+
+		if !waited {
+			mon.Counter("slow_start_wait").Inc(1)
+			waited = true
+		}
+
+		timer := time.NewTimer(step)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// release releases an allowed request.
+func (slow *slowStart) release() {
+	slow.mu.Lock()
+	defer slow.mu.Unlock()
+
+	slow.active--
+	close(slow.changed)
+	slow.changed = make(chan struct{})
+}
+
+// layerSlowStart limits the concurrent object requests of the wrapped layer
+// by a slow start. Parts of multipart uploads aren't limited, see layerFair.
+type layerSlowStart struct {
+	minio.ObjectLayer
+	slow *slowStart
+}
+
+func (slow *layerSlowStart) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
+	release, err := slow.slow.acquire(ctx)
+	if err != nil {
+		return minio.ListObjectsInfo{}, err
+	}
+	defer release()
+	return slow.ObjectLayer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
+}
+
+func (slow *layerSlowStart) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
+	release, err := slow.slow.acquire(ctx)
+	if err != nil {
+		return minio.ListObjectsV2Info{}, err
+	}
+	defer release()
+	return slow.ObjectLayer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+}
+
+func (slow *layerSlowStart) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
+	release, err := slow.slow.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err = slow.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rs, h, lockType, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// the request is in progress until the content is read
+	closer := func() {
+		_ = reader.Close()
+		release()
+	}
+	return minio.NewGetObjectReaderFromReader(reader, reader.ObjInfo, minio.ObjectOptions{}, closer)
+}
+
+func (slow *layerSlowStart) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
+	release, err := slow.slow.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return slow.ObjectLayer.GetObject(ctx, bucket, object, startOffset, length, writer, etag, opts)
+}
+
+func (slow *layerSlowStart) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	release, err := slow.slow.acquire(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return slow.ObjectLayer.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+func (slow *layerSlowStart) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	release, err := slow.slow.acquire(ctx)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer release()
+	return slow.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+}
+
+// CopyObject isn't limited, as minio copies while it holds the reader of the
+// source, which holds a slot already.
+
+func (slow *layerSlowStart) DeleteObject(ctx context.Context, bucket, object string) error {
+	release, err := slow.slow.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return slow.ObjectLayer.DeleteObject(ctx, bucket, object)
+}
+
+func (slow *layerSlowStart) DeleteObjects(ctx context.Context, bucket string, objects []string) ([]error, error) {
+	release, err := slow.slow.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return slow.ObjectLayer.DeleteObjects(ctx, bucket, objects)
+}
@@ -0,0 +1,27 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+
+	minio "github.com/minio/minio/cmd"
+	objectlock "github.com/minio/minio/pkg/bucket/object/lock"
+)
+
+// layerStrictDelete rejects multi-object delete requests without any object
+// as malformed, as S3 does, instead of responding with an empty result.
+// Whether a request is empty is decided by its body, as minio also calls
+// DeleteObjects without objects when it has denied all objects of a request,
+// which then get their own errors.
+type layerStrictDelete struct {
+	minio.ObjectLayer
+}
+
+func (strict *layerStrictDelete) DeleteObjects(ctx context.Context, bucket string, objects []string) ([]error, error) {
+	if getRequest(ctx).emptyDelete {
+		return nil, objectlock.ErrMalformedXML
+	}
+	return strict.ObjectLayer.DeleteObjects(ctx, bucket, objects)
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"context"
+	"io"
+
+	"storj.io/uplink"
+)
+
+// transformedKey is the custom metadata key of objects stored with their
+// content transformed.
+const transformedKey = "s3:transformed"
+
+// Transform transforms the content of objects when they are uploaded and
+// reverts the transformation when they are downloaded.
+//
+// Transforms must preserve the length of the content, as the stored size is
+// reported as the size of objects and ranges are read from the stored content.
+// ETags are computed from the original content.
+type Transform interface {
+	// Encode returns the content to store for the uploaded content.
+	Encode(ctx context.Context, bucket, key string, content io.Reader) (io.Reader, error)
+	// Decode returns the original content for the stored content, which is
+	// read from the offset in the object.
+	Decode(ctx context.Context, bucket, key string, offset int64, stored io.Reader) (io.Reader, error)
+}
+
+// encode applies the configured transforms in order to the uploaded content.
+// It marks the metadata of the object as transformed, if there are any.
+func (gateway *Gateway) encode(ctx context.Context, bucketName, objectPath string, content io.Reader, metadata map[string]string) (_ io.Reader, err error) {
+	if len(gateway.config.Transforms) == 0 {
+		return content, nil
+	}
+
+	for _, transform := range gateway.config.Transforms {
+		content, err = transform.Encode(ctx, bucketName, objectPath, content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	metadata[transformedKey] = "true"
+	return content, nil
+}
+
+// decode reverts the configured transforms in reverse order for the content
+// of transformed objects, read from the offset.
+func (gateway *Gateway) decode(ctx context.Context, bucketName string, object *uplink.Object, offset int64, stored io.Reader) (_ io.Reader, err error) {
+	if _, ok := object.Custom[transformedKey]; !ok {
+		return stored, nil
+	}
+
+	transforms := gateway.config.Transforms
+	if len(transforms) == 0 {
+		return nil, Error.New("content of %q is transformed, but no transforms are configured", object.Key)
+	}
+
+	for i := len(transforms) - 1; i >= 0; i-- {
+		stored, err = transforms[i].Decode(ctx, bucketName, object.Key, offset, stored)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
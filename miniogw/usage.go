@@ -0,0 +1,235 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/zeebo/errs"
+)
+
+// UsageConfig configures the export of the bandwidth used per access key,
+// e.g. for billing.
+type UsageConfig struct {
+	File     string        `help:"file to append the bandwidth used per access key to as JSON lines" default:""`
+	Endpoint string        `help:"URL to POST the bandwidth used per access key to as a JSON array" default:""`
+	Interval time.Duration `help:"how often the bandwidth used per access key is exported" default:"1h"`
+}
+
+// enabled reports whether the usage is exported to any sink.
+func (config UsageConfig) enabled() bool {
+	return config.File != "" || config.Endpoint != ""
+}
+
+// Usage is the bandwidth used by an access key in a period.
+type Usage struct {
+	// AccessKey is the access key the requests were signed with, it's empty
+	// for anonymous requests.
+	AccessKey string    `json:"access_key"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	// Ingress is the number of bytes of content uploaded.
+	Ingress int64 `json:"ingress"`
+	// Egress is the number of bytes of content downloaded.
+	Egress int64 `json:"egress"`
+}
+
+// usageAccounts accumulates the bandwidth used per access key since the last
+// export.
+type usageAccounts struct {
+	mu      sync.Mutex
+	start   time.Time
+	ingress map[string]int64
+	egress  map[string]int64
+}
+
+// add accounts the bytes uploaded and downloaded with the access key.
+func (accounts *usageAccounts) add(accessKey string, ingress, egress int64) {
+	if ingress == 0 && egress == 0 {
+		return
+	}
+
+	accounts.mu.Lock()
+	defer accounts.mu.Unlock()
+
+	if accounts.ingress == nil {
+		accounts.ingress = make(map[string]int64)
+		accounts.egress = make(map[string]int64)
+	}
+	accounts.ingress[accessKey] += ingress
+	accounts.egress[accessKey] += egress
+}
+
+// take returns the usage accumulated since the last call and resets it.
+func (accounts *usageAccounts) take(now time.Time) []Usage {
+	accounts.mu.Lock()
+	defer accounts.mu.Unlock()
+
+	start := accounts.start
+	accounts.start = now
+
+	var usages []Usage
+	for accessKey, ingress := range accounts.ingress {
+		usages = append(usages, Usage{
+			AccessKey: accessKey,
+			Start:     start,
+			End:       now,
+			Ingress:   ingress,
+			Egress:    accounts.egress[accessKey],
+		})
+	}
+	sort.Slice(usages, func(i, k int) bool {
+		return usages[i].AccessKey < usages[k].AccessKey
+	})
+
+	accounts.ingress = nil
+	accounts.egress = nil
+	return usages
+}
+
+// ExportUsage exports the bandwidth used per access key since the last export
+// to the configured sinks. The usage that fails to be exported is dropped.
+func (gateway *Gateway) ExportUsage(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	usages := gateway.usage.take(time.Now().UTC())
+	if len(usages) == 0 {
+		return nil
+	}
+
+	config := gateway.config.Usage
+	if config.File != "" {
+		err = errs.Combine(err, appendUsage(config.File, usages))
+	}
+	if config.Endpoint != "" {
+		err = errs.Combine(err, postUsage(ctx, config.Endpoint, usages))
+	}
+	return Error.Wrap(err)
+}
+
+// appendUsage appends the usages to the file as JSON lines.
+func appendUsage(path string, usages []Usage) (err error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, file.Close()) }()
+
+	encoder := json.NewEncoder(file)
+	for _, usage := range usages {
+		if err := encoder.Encode(usage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postUsage posts the usages to the endpoint as a JSON array.
+func postUsage(ctx context.Context, endpoint string, usages []Usage) error {
+	data, err := json.Marshal(usages)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return errs.New("usage endpoint responded with %s", response.Status)
+	}
+	return nil
+}
+
+func (gateway *Gateway) exportUsageEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := gateway.ExportUsage(context.Background()); err != nil {
+				mon.Counter("usage_export_failed").Inc(1)
+			}
+		case <-gateway.closed:
+			return
+		}
+	}
+}
+
+// layerUsage accounts the content uploaded and downloaded with the wrapped
+// layer to the access key of each request.
+type layerUsage struct {
+	minio.ObjectLayer
+	accounts *usageAccounts
+}
+
+func (usage *layerUsage) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
+	reader, err = usage.ObjectLayer.GetObjectNInfo(ctx, bucket, object, rs, h, lockType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the downloaded bytes are accounted once the content is read
+	accessKey := getRequest(ctx).accessKey
+	counting := &countingReader{reader: reader}
+	closer := func() {
+		_ = reader.Close()
+		usage.accounts.add(accessKey, 0, counting.count)
+	}
+	return minio.NewGetObjectReaderFromReader(counting, reader.ObjInfo, minio.ObjectOptions{}, closer)
+}
+
+func (usage *layerUsage) GetObject(ctx context.Context, bucket, object string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) (err error) {
+	counting := &countingWriter{writer: writer}
+	defer func() { usage.accounts.add(getRequest(ctx).accessKey, 0, counting.count) }()
+	return usage.ObjectLayer.GetObject(ctx, bucket, object, startOffset, length, counting, etag, opts)
+}
+
+func (usage *layerUsage) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	objInfo, err = usage.ObjectLayer.PutObject(ctx, bucket, object, data, opts)
+	if err == nil {
+		usage.accounts.add(getRequest(ctx).accessKey, objInfo.Size, 0)
+	}
+	return objInfo, err
+}
+
+func (usage *layerUsage) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	info, err = usage.ObjectLayer.PutObjectPart(ctx, bucket, object, uploadID, partID, data, opts)
+	if err == nil {
+		usage.accounts.add(getRequest(ctx).accessKey, info.Size, 0)
+	}
+	return info, err
+}
+
+// countingReader counts the bytes read from the reader.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+// Read implements io.Reader.
+func (counting *countingReader) Read(p []byte) (n int, err error) {
+	n, err = counting.reader.Read(p)
+	counting.count += int64(n)
+	return n, err
+}
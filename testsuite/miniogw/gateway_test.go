@@ -6,17 +6,28 @@ package miniogw_test
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	miniov6 "github.com/minio/minio-go/v6"
 	minio "github.com/minio/minio/cmd"
 	"github.com/minio/minio/pkg/auth"
+	objectlock "github.com/minio/minio/pkg/bucket/object/lock"
+	"github.com/minio/minio/pkg/event"
 	"github.com/minio/minio/pkg/hash"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -26,6 +37,7 @@ import (
 	"storj.io/common/pb"
 	"storj.io/common/storj"
 	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
 	"storj.io/gateway/miniogw"
 	olduplink "storj.io/storj/lib/uplink"
 	"storj.io/storj/private/testplanet"
@@ -46,6 +58,8 @@ const (
 	DestBucket = "dest-bucket"
 	DestFile   = "dest-file"
 	TestAPIKey = "test-api-key"
+
+	TestAccessKey = "test-access-key"
 )
 
 func TestMakeBucketWithLocation(t *testing.T) {
@@ -238,240 +252,1601 @@ func TestPutObject(t *testing.T) {
 	})
 }
 
-func TestGetObjectInfo(t *testing.T) {
-	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
-		// Check the error when getting an object from a bucket with empty name
-		_, err := layer.GetObjectInfo(ctx, "", "", minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNameInvalid{}, err)
-
-		// Check the error when getting an object from non-existing bucket
-		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
-
-		// Create the bucket using the Metainfo API
+func TestPutObjectDedup(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{DedupBucket: "dedup"}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
 		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
-		assert.NoError(t, err)
+		require.NoError(t, err)
+		dedupBucketInfo, err := m.CreateBucket(ctx, "dedup", nil)
+		require.NoError(t, err)
 
-		// Check the error when getting an object with empty name
-		_, err = layer.GetObjectInfo(ctx, TestBucket, "", minio.ObjectOptions{})
-		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+		data := []byte("deduplicated content")
+		sum := sha256.Sum256(data)
 
-		// Check the error when getting a non-existing object
-		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
-		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+		for _, path := range []string{TestFile, TestFile2} {
+			hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", hex.EncodeToString(sum[:]), int64(len(data)), true)
+			require.NoError(t, err)
 
-		// Create the object using the Metainfo API
-		createInfo := kvmetainfo.CreateObject{
-			ContentType: "text/plain",
-			Metadata:    map[string]string{"key1": "value1", "key2": "value2"},
+			info, err := layer.PutObject(ctx, TestBucket, path, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{UserDefined: map[string]string{}})
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), info.Size)
 		}
-		obj, err := createFile(ctx, m, strms, testBucketInfo, TestFile, &createInfo, []byte("test"))
-		assert.NoError(t, err)
 
-		// Get the object info using the Minio API
-		info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
-		if assert.NoError(t, err) {
-			assert.Equal(t, TestFile, info.Name)
-			assert.Equal(t, TestBucket, info.Bucket)
-			assert.False(t, info.IsDir)
-			assert.Equal(t, obj.Created, info.ModTime)
-			assert.Equal(t, obj.Size, info.Size)
-			assert.Equal(t, hex.EncodeToString(obj.Checksum), info.ETag)
-			assert.Equal(t, createInfo.ContentType, info.ContentType)
-			assert.Equal(t, createInfo.Metadata, info.UserDefined)
+		// The content is stored once, the objects only reference it
+		content, err := m.GetObject(ctx, dedupBucketInfo, hex.EncodeToString(sum[:]))
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), content.Size)
+
+		for _, path := range []string{TestFile, TestFile2} {
+			obj, err := m.GetObject(ctx, testBucketInfo, path)
+			require.NoError(t, err)
+			assert.Zero(t, obj.Size)
+
+			info, err := layer.GetObjectInfo(ctx, TestBucket, path, minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), info.Size)
+
+			var buf bytes.Buffer
+			err = layer.GetObject(ctx, TestBucket, path, 0, -1, &buf, "", minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, data, buf.Bytes())
+
+			reader, err := layer.GetObjectNInfo(ctx, TestBucket, path, &minio.HTTPRangeSpec{Start: 1, End: 3}, nil, 0, minio.ObjectOptions{})
+			require.NoError(t, err)
+			ranged, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			assert.Equal(t, data[1:4], ranged)
 		}
+
+		// Uploads without a declared content hash are stored as usual
+		_, err = putObject(ctx, layer, TestBucket, TestFile3, data, nil)
+		require.NoError(t, err)
+
+		obj, err := m.GetObject(ctx, testBucketInfo, TestFile3)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), obj.Size)
 	})
 }
 
-func TestGetObjectNInfo(t *testing.T) {
-	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
-		// Check the error when getting an object from a bucket with empty name
-		_, err := layer.GetObjectNInfo(ctx, "", "", nil, nil, 0, minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNameInvalid{}, err)
-
-		// Check the error when getting an object from non-existing bucket
-		_, err = layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+func TestPutObjectWebhook(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		var attempts int64
+		deliveries := make(chan event.Log, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// fail the first delivery to verify it is retried
+			if atomic.AddInt64(&attempts, 1) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
 
-		// Create the bucket using the Metainfo API
-		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
-		assert.NoError(t, err)
+			var entry event.Log
+			if assert.NoError(t, json.NewDecoder(r.Body).Decode(&entry)) {
+				deliveries <- entry
+			}
+		}))
+		defer server.Close()
+
+		const alias = "alias-bucket"
+		gateway, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{
+			Webhook: miniogw.WebhookConfig{
+				Endpoint:   server.URL,
+				QueueSize:  10,
+				MaxRetries: 3,
+				RetryDelay: time.Millisecond,
+				DrainDelay: 10 * time.Second,
+			},
+			BucketAliases: map[string]string{alias: TestBucket},
+		})
+		require.NoError(t, err)
+		defer ctx.Check(gateway.Close)
 
-		// Check the error when getting an object with empty name
-		_, err = layer.GetObjectNInfo(ctx, TestBucket, "", nil, nil, 0, minio.ObjectOptions{})
-		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+		_, err = m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
 
-		// Check the error when getting a non-existing object
-		_, err = layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
-		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+		info, err := putObject(ctx, layer, TestBucket, TestFile, []byte("test"), nil)
+		require.NoError(t, err)
 
-		// Create the object using the Metainfo API
-		createInfo := kvmetainfo.CreateObject{
-			ContentType: "text/plain",
-			Metadata:    map[string]string{"key1": "value1", "key2": "value2"},
+		select {
+		case entry := <-deliveries:
+			assert.Equal(t, event.ObjectCreatedPut, entry.EventName)
+			assert.Equal(t, TestBucket+"/"+TestFile, entry.Key)
+			require.Len(t, entry.Records, 1)
+			record := entry.Records[0]
+			assert.Equal(t, event.ObjectCreatedPut, record.EventName)
+			assert.Equal(t, TestBucket, record.S3.Bucket.Name)
+			assert.Equal(t, TestFile, record.S3.Object.Key)
+			assert.Equal(t, int64(4), record.S3.Object.Size)
+			assert.Equal(t, info.ETag, record.S3.Object.ETag)
+		case <-time.After(10 * time.Second):
+			t.Fatal("webhook was not called")
 		}
-		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile, &createInfo, []byte("abcdef"))
-		assert.NoError(t, err)
-
-		for i, tt := range []struct {
-			rangeSpec *minio.HTTPRangeSpec
-			substr    string
-			err       error
-		}{
-			{rangeSpec: nil, substr: "abcdef"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 0}, substr: "a"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 3, End: 3}, substr: "d"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: -1}, substr: "abcdef"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 3, End: -1}, substr: "def"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 5}, substr: "abcdef"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 4}, substr: "abcde"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 3}, substr: "abcd"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 1, End: 4}, substr: "bcde"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 2, End: 5}, substr: "cdef"},
-			{rangeSpec: &minio.HTTPRangeSpec{IsSuffixLength: true, Start: 0}, substr: ""},
-			{rangeSpec: &minio.HTTPRangeSpec{IsSuffixLength: true, Start: -2}, substr: "ef"},
-			{rangeSpec: &minio.HTTPRangeSpec{IsSuffixLength: true, Start: -100}, substr: "abcdef"},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 7}, substr: "", err: minio.InvalidRange{OffsetBegin: 0, OffsetEnd: 7, ResourceSize: 6}},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: -1, End: 3}, substr: "", err: minio.InvalidRange{OffsetBegin: -1, OffsetEnd: 3, ResourceSize: 6}},
-			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: -2}, substr: "", err: errs.New("Unexpected range specification case")},
-			{rangeSpec: &minio.HTTPRangeSpec{IsSuffixLength: true, Start: 1}, substr: "", err: errs.New("Unexpected range specification case")},
-		} {
-			errTag := fmt.Sprintf("%d. %v", i, tt)
 
-			// Get the object info using the Minio API
-			reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, tt.rangeSpec, nil, 0, minio.ObjectOptions{})
+		err = layer.DeleteObject(ctx, TestBucket, TestFile)
+		require.NoError(t, err)
 
-			if tt.err != nil {
-				assert.EqualError(t, err, tt.err.Error(), errTag)
-			} else if assert.NoError(t, err) {
-				data, err := ioutil.ReadAll(reader)
-				assert.NoError(t, err, errTag)
+		select {
+		case entry := <-deliveries:
+			assert.Equal(t, event.ObjectRemovedDelete, entry.EventName)
+			assert.Equal(t, TestBucket+"/"+TestFile, entry.Key)
+		case <-time.After(10 * time.Second):
+			t.Fatal("webhook was not called")
+		}
 
-				err = reader.Close()
-				assert.NoError(t, err, errTag)
+		// Completed multipart uploads are notified with the names the client
+		// used
+		uploadID, err := layer.NewMultipartUpload(ctx, alias, TestFile2, minio.ObjectOptions{})
+		require.NoError(t, err)
+		hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+		require.NoError(t, err)
+		part, err := layer.PutObjectPart(ctx, alias, TestFile2, uploadID, 1, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = layer.CompleteMultipartUpload(ctx, alias, TestFile2, uploadID, []minio.CompletePart{{PartNumber: part.PartNumber, ETag: part.ETag}}, minio.ObjectOptions{})
+		require.NoError(t, err)
 
-				assert.Equal(t, tt.substr, string(data), errTag)
-			}
+		select {
+		case entry := <-deliveries:
+			assert.Equal(t, event.ObjectCreatedCompleteMultipartUpload, entry.EventName)
+			assert.Equal(t, alias+"/"+TestFile2, entry.Key)
+			require.Len(t, entry.Records, 1)
+			assert.Equal(t, alias, entry.Records[0].S3.Bucket.Name)
+			assert.Equal(t, int64(4), entry.Records[0].S3.Object.Size)
+		case <-time.After(10 * time.Second):
+			t.Fatal("webhook was not called")
 		}
+
+		// The notifications still queued are delivered when the gateway is
+		// closed
+		deleteErrs, err := layer.DeleteObjects(ctx, alias, []string{TestFile2})
+		require.NoError(t, err)
+		require.NoError(t, deleteErrs[0])
+		_, err = putObject(ctx, layer, TestBucket, TestFile3, []byte("test"), nil)
+		require.NoError(t, err)
+		require.NoError(t, gateway.Close())
+
+		require.Len(t, deliveries, 2)
+		entry := <-deliveries
+		assert.Equal(t, event.ObjectRemovedDelete, entry.EventName)
+		assert.Equal(t, alias+"/"+TestFile2, entry.Key)
+		entry = <-deliveries
+		assert.Equal(t, event.ObjectCreatedPut, entry.EventName)
+		assert.Equal(t, TestBucket+"/"+TestFile3, entry.Key)
 	})
 }
 
-func TestGetObject(t *testing.T) {
-	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
-		// Check the error when getting an object from a bucket with empty name
-		err := layer.GetObject(ctx, "", "", 0, 0, nil, "", minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNameInvalid{}, err)
-
-		// Check the error when getting an object from non-existing bucket
-		err = layer.GetObject(ctx, TestBucket, TestFile, 0, 0, nil, "", minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+func TestSearchObjectsByMetadata(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		gateway, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{
+			Index: miniogw.IndexConfig{Keys: "color", Prefix: ".index/"},
+		})
+		require.NoError(t, err)
 
-		// Create the bucket using the Metainfo API
-		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
-		assert.NoError(t, err)
+		_, err = m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
 
-		// Check the error when getting an object with empty name
-		err = layer.GetObject(ctx, TestBucket, "", 0, 0, nil, "", minio.ObjectOptions{})
-		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+		for path, color := range map[string]string{
+			TestFile:  "red",
+			TestFile2: "red",
+			TestFile3: "blue",
+		} {
+			_, err := putObject(ctx, layer, TestBucket, path, []byte("test"), map[string]string{
+				"X-Amz-Meta-Color": color,
+				"X-Amz-Meta-Size":  "small",
+			})
+			require.NoError(t, err)
+		}
 
-		// Check the error when getting a non-existing object
-		err = layer.GetObject(ctx, TestBucket, TestFile, 0, 0, nil, "", minio.ObjectOptions{})
-		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+		search := func(key, value string) []string {
+			query := url.Values{"bucket": {TestBucket}, "key": {key}, "value": {value}}
+			recorder := httptest.NewRecorder()
+			gateway.IndexHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+			require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
 
-		// Create the object using the Metainfo API
-		createInfo := kvmetainfo.CreateObject{
-			ContentType: "text/plain",
-			Metadata:    map[string]string{"key1": "value1", "key2": "value2"},
+			var keys []string
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&keys))
+			return keys
 		}
-		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile, &createInfo, []byte("abcdef"))
-		assert.NoError(t, err)
 
-		for i, tt := range []struct {
-			offset, length int64
-			substr         string
-			err            error
-		}{
-			{offset: 0, length: 0, substr: ""},
-			{offset: 3, length: 0, substr: ""},
-			{offset: 0, length: -1, substr: "abcdef"},
-			{offset: 0, length: 6, substr: "abcdef"},
-			{offset: 0, length: 5, substr: "abcde"},
-			{offset: 0, length: 4, substr: "abcd"},
-			{offset: 1, length: 4, substr: "bcde"},
-			{offset: 2, length: 4, substr: "cdef"},
-			{offset: 0, length: 7, substr: "", err: minio.InvalidRange{OffsetBegin: 0, OffsetEnd: 7, ResourceSize: 6}},
-			{offset: -1, length: 7, substr: "", err: minio.InvalidRange{OffsetBegin: -1, OffsetEnd: 6, ResourceSize: 6}},
-			{offset: 0, length: -2, substr: "", err: minio.InvalidRange{OffsetBegin: 0, OffsetEnd: -2, ResourceSize: 6}},
-		} {
-			errTag := fmt.Sprintf("%d. %+v", i, tt)
+		assert.Equal(t, []string{TestFile, TestFile2}, search("color", "red"))
+		assert.Equal(t, []string{TestFile3}, search("color", "blue"))
+		assert.Empty(t, search("size", "small"), "only configured keys are indexed")
 
-			var buf bytes.Buffer
+		// Replacing and deleting objects updates the index
+		_, err = putObject(ctx, layer, TestBucket, TestFile2, []byte("test"), map[string]string{"X-Amz-Meta-Color": "blue"})
+		require.NoError(t, err)
+		require.NoError(t, layer.DeleteObject(ctx, TestBucket, TestFile3))
 
-			// Get the object info using the Minio API
-			err = layer.GetObject(ctx, TestBucket, TestFile, tt.offset, tt.length, &buf, "", minio.ObjectOptions{})
+		assert.Equal(t, []string{TestFile}, search("color", "red"))
+		assert.Equal(t, []string{TestFile2}, search("color", "blue"))
 
-			if tt.err != nil {
-				assert.Equal(t, tt.err, err, errTag)
-			} else if assert.NoError(t, err) {
-				assert.Equal(t, tt.substr, buf.String(), errTag)
-			}
+		// Copies update the index of the destination
+		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		replaced := srcInfo
+		replaced.UserDefined = map[string]string{"X-Amz-Meta-Color": "green"}
+		_, err = layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, TestFile, replaced, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{DestFile}, search("color", "red"))
+		assert.Equal(t, []string{TestFile}, search("color", "green"))
+
+		// Multipart uploads update the index when they are completed
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile3, minio.ObjectOptions{UserDefined: map[string]string{"X-Amz-Meta-Color": "blue"}})
+		require.NoError(t, err)
+		hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+		require.NoError(t, err)
+		part, err := layer.PutObjectPart(ctx, TestBucket, TestFile3, uploadID, 1, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile3, uploadID, []minio.CompletePart{{PartNumber: part.PartNumber, ETag: part.ETag}}, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{TestFile2, TestFile3}, search("color", "blue"))
+
+		// The index is not listed with the objects
+		list, err := layer.ListObjects(ctx, TestBucket, "", "", "", 0)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 4)
+		for _, object := range list.Objects {
+			assert.False(t, strings.HasPrefix(object.Name, ".index/"), object.Name)
 		}
 	})
 }
 
-func TestCopyObject(t *testing.T) {
-	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
-		// Check the error when copying an object from a bucket with empty name
-		_, err := layer.CopyObject(ctx, "", TestFile, DestBucket, DestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNameInvalid{}, err)
+func TestDenyReservedKeys(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		gateway, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{
+			Index:            miniogw.IndexConfig{Keys: "color", Prefix: ".index/"},
+			DenyReservedKeys: true,
+			ReservedPrefixes: []string{".trash/"},
+		})
+		require.NoError(t, err)
 
-		// Check the error when copying an object from non-existing bucket
-		_, err = layer.CopyObject(ctx, TestBucket, TestFile, DestBucket, DestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+		_, err = m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
 
-		// Create the source bucket using the Metainfo API
-		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
-		assert.NoError(t, err)
+		// Client requests to the reserved prefixes are denied
+		for _, key := range []string{".index/color/red/" + TestFile, ".trash/" + TestFile} {
+			_, err = putObject(ctx, layer, TestBucket, key, []byte("test"), nil)
+			assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: key}, err)
 
-		// Check the error when copying an object with empty name
-		_, err = layer.CopyObject(ctx, TestBucket, "", DestBucket, DestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
-		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+			_, err = layer.GetObjectInfo(ctx, TestBucket, key, minio.ObjectOptions{})
+			assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: key}, err)
 
-		// Create the source object using the Metainfo API
-		createInfo := kvmetainfo.CreateObject{
-			ContentType: "text/plain",
-			Metadata:    map[string]string{"key1": "value1", "key2": "value2"},
+			err = layer.DeleteObject(ctx, TestBucket, key)
+			assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: key}, err)
 		}
-		obj, err := createFile(ctx, m, strms, testBucketInfo, TestFile, &createInfo, []byte("test"))
-		assert.NoError(t, err)
 
-		// Get the source object info using the Minio API
-		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
-		assert.NoError(t, err)
+		_, err = layer.ListObjects(ctx, TestBucket, ".index/", "", "", 0)
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: ".index/"}, err)
 
-		// Check the error when copying an object to a bucket with empty name
-		_, err = layer.CopyObject(ctx, TestBucket, TestFile, "", DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNameInvalid{}, err)
+		// The internal index is still written for client uploads
+		_, err = putObject(ctx, layer, TestBucket, TestFile, []byte("test"), map[string]string{"X-Amz-Meta-Color": "red"})
+		require.NoError(t, err)
 
-		// Check the error when copying an object to a non-existing bucket
-		_, err = layer.CopyObject(ctx, TestBucket, TestFile, DestBucket, DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
-		assert.Equal(t, minio.BucketNotFound{Bucket: DestBucket}, err)
+		query := url.Values{"bucket": {TestBucket}, "key": {"color"}, "value": {"red"}}
+		recorder := httptest.NewRecorder()
+		gateway.IndexHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
 
-		// Create the destination bucket using the Metainfo API
-		destBucketInfo, err := m.CreateBucket(ctx, DestBucket, nil)
-		assert.NoError(t, err)
+		var keys []string
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&keys))
+		assert.Equal(t, []string{TestFile}, keys)
+	})
+}
 
-		// Copy the object using the Minio API
-		info, err := layer.CopyObject(ctx, TestBucket, TestFile, DestBucket, DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
-		if assert.NoError(t, err) {
-			assert.Equal(t, DestFile, info.Name)
-			assert.Equal(t, DestBucket, info.Bucket)
-			assert.False(t, info.IsDir)
-			assert.True(t, info.ModTime.Sub(obj.Modified) < 1*time.Minute)
-			assert.Equal(t, obj.Size, info.Size)
-			assert.Equal(t, createInfo.ContentType, info.ContentType)
-			assert.Equal(t, createInfo.Metadata, info.UserDefined)
-		}
+func TestExportUsage(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		usageFile := ctx.File("usage.jsonl")
+
+		gateway, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{
+			Usage: miniogw.UsageConfig{File: usageFile},
+		})
+		require.NoError(t, err)
+
+		// The usage is of the access key each request is signed with
+		const otherAccessKey = "other-access-key"
+		first, other := signedContext(ctx, TestAccessKey), signedContext(ctx, otherAccessKey)
+
+		_, err = m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(1000)
+
+		// Upload and download concurrently with both access keys
+		var wg sync.WaitGroup
+		errors := make(chan error, 10)
+		for i := 0; i < 5; i++ {
+			object := fmt.Sprintf("%s-%d", TestFile, i)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := putObject(first, layer, TestBucket, object, data, nil)
+				if err == nil {
+					err = layer.GetObject(first, TestBucket, object, 0, -1, ioutil.Discard, "", minio.ObjectOptions{})
+				}
+				errors <- err
+			}()
+			go func() {
+				defer wg.Done()
+				_, err := putObject(other, layer, TestBucket, object+"-other", data[:100], nil)
+				errors <- err
+			}()
+		}
+		wg.Wait()
+		close(errors)
+		for err := range errors {
+			require.NoError(t, err)
+		}
+
+		reader, err := layer.GetObjectNInfo(other, TestBucket, TestFile+"-0", &minio.HTTPRangeSpec{Start: 0, End: 9}, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+
+		readUsages := func() map[string]miniogw.Usage {
+			data, err := ioutil.ReadFile(usageFile)
+			require.NoError(t, err)
+
+			usages := make(map[string]miniogw.Usage)
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			for decoder.More() {
+				var usage miniogw.Usage
+				require.NoError(t, decoder.Decode(&usage))
+				usages[usage.AccessKey] = usage
+			}
+			return usages
+		}
+
+		require.NoError(t, gateway.ExportUsage(ctx))
+
+		// Anonymous requests are accounted without an access key
+		anonymous := miniogw.WithRequest(ctx, httptest.NewRequest(http.MethodGet, "/"+TestBucket+"/"+TestFile+"-0", nil))
+		err = layer.GetObject(anonymous, TestBucket, TestFile+"-0", 0, -1, ioutil.Discard, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		usages := readUsages()
+		require.Len(t, usages, 3)
+		assert.Equal(t, int64(5*len(data)), usages[TestAccessKey].Ingress)
+		assert.Equal(t, int64(5*len(data)), usages[TestAccessKey].Egress)
+		assert.Equal(t, int64(5*100), usages[otherAccessKey].Ingress)
+		assert.Equal(t, int64(10), usages[otherAccessKey].Egress)
+		assert.Equal(t, int64(len(data)), usages[""].Egress)
+
+		// The exported usage is reset, so nothing is exported again
+		exported, err := ioutil.ReadFile(usageFile)
+		require.NoError(t, err)
+		require.NoError(t, gateway.ExportUsage(ctx))
+		again, err := ioutil.ReadFile(usageFile)
+		require.NoError(t, err)
+		assert.Equal(t, exported, again)
+	})
+}
+
+func TestBucketLogging(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		const alias = "alias-bucket"
+		config := miniogw.Config{
+			AccessLogFile: ctx.File("logging.json"),
+			BucketAliases: map[string]string{alias: TestBucket},
+		}
+		gateway, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, config)
+		require.NoError(t, err)
+		defer ctx.Check(gateway.Close)
+
+		_, err = m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+		_, err = m.CreateBucket(ctx, DestBucket, nil)
+		require.NoError(t, err)
+
+		assert.Nil(t, gateway.GetBucketLogging(TestBucket))
+		require.NoError(t, gateway.PutBucketLogging(TestBucket, &miniogw.BucketLogging{TargetBucket: DestBucket, TargetPrefix: "logs/"}))
+		assert.Equal(t, &miniogw.BucketLogging{TargetBucket: DestBucket, TargetPrefix: "logs/"}, gateway.GetBucketLogging(TestBucket))
+
+		// The logs of other buckets are written even if one target fails
+		require.NoError(t, gateway.PutBucketLogging(DestBucket, &miniogw.BucketLogging{TargetBucket: "missing-bucket"}))
+		_, err = layer.GetObjectInfo(ctx, DestBucket, TestFile, minio.ObjectOptions{})
+		require.Error(t, err)
+
+		_, err = putObject(ctx, layer, TestBucket, TestFile, []byte("test"), nil)
+		require.NoError(t, err)
+		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		require.NoError(t, layer.DeleteObject(ctx, TestBucket, TestFile))
+		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.Error(t, err)
+
+		require.Error(t, gateway.FlushAccessLogs(ctx))
+		require.NoError(t, gateway.PutBucketLogging(DestBucket, nil))
+
+		list, err := layer.ListObjects(ctx, DestBucket, "logs/", "", "", 0)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 1)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, DestBucket, list.Objects[0].Name, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 4)
+		for i, operation := range []string{
+			"REST.PUT.OBJECT " + TestFile + " \"-\" 200 - - 4 ",
+			"REST.HEAD.OBJECT " + TestFile + " \"-\" 200 - - 4 ",
+			"REST.DELETE.OBJECT " + TestFile + " \"-\" 200 - - - ",
+			"REST.HEAD.OBJECT " + TestFile + " \"-\" 404 NoSuchKey - - ",
+		} {
+			assert.True(t, strings.HasPrefix(lines[i], "- "+TestBucket+" ["), lines[i])
+			assert.Contains(t, lines[i], operation)
+		}
+
+		// The configuration is loaded by gateways using the same file
+		other, _, _, _, err := initEnv(ctx, t, planet, storj.EncNull, config)
+		require.NoError(t, err)
+		defer ctx.Check(other.Close)
+		require.NoError(t, other.LoadBucketLogging())
+		assert.Equal(t, &miniogw.BucketLogging{TargetBucket: DestBucket, TargetPrefix: "logs/"}, other.GetBucketLogging(TestBucket))
+		assert.Nil(t, other.GetBucketLogging(DestBucket))
+
+		// Logging can be disabled again
+		require.NoError(t, gateway.PutBucketLogging(TestBucket, nil))
+		assert.Nil(t, gateway.GetBucketLogging(TestBucket))
+
+		// The bucket and the key are logged as the client uses them, with
+		// the key URL encoded
+		require.NoError(t, gateway.PutBucketLogging(alias, &miniogw.BucketLogging{TargetBucket: DestBucket, TargetPrefix: "alias-logs/"}))
+		_, err = putObject(ctx, layer, alias, "with space", []byte("test"), nil)
+		require.NoError(t, err)
+		require.NoError(t, gateway.FlushAccessLogs(ctx))
+
+		list, err = layer.ListObjects(ctx, DestBucket, "alias-logs/", "", "", 0)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 1)
+
+		buf.Reset()
+		err = layer.GetObject(ctx, DestBucket, list.Objects[0].Name, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(buf.String(), "- "+alias+" ["), buf.String())
+		assert.Contains(t, buf.String(), "REST.PUT.OBJECT with%20space \"-\" 200 ")
+	})
+}
+
+func TestAccessLogHandler(t *testing.T) {
+	gateway := miniogw.NewStorjGateway(&uplink.Access{}, uplink.Config{}, miniogw.Config{})
+	defer func() { require.NoError(t, gateway.Close()) }()
+
+	credentials := auth.Credentials{AccessKey: TestAccessKey, SecretKey: "secret-key"}
+	server := httptest.NewServer(gateway.AccessLogHandler(credentials))
+	defer server.Close()
+
+	get := func() string {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"?bucket="+TestBucket, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth(credentials.AccessKey, credentials.SecretKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, resp.Body.Close()) }()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	put := func(body string) int {
+		req, err := http.NewRequest(http.MethodPut, server.URL+"?bucket="+TestBucket, strings.NewReader(body))
+		require.NoError(t, err)
+		req.SetBasicAuth(credentials.AccessKey, credentials.SecretKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, "<BucketLoggingStatus></BucketLoggingStatus>", get())
+
+	status := put(`<BucketLoggingStatus xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LoggingEnabled><TargetBucket>` + DestBucket + `</TargetBucket><TargetPrefix>logs/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, &miniogw.BucketLogging{TargetBucket: DestBucket, TargetPrefix: "logs/"}, gateway.GetBucketLogging(TestBucket))
+	assert.Equal(t, "<BucketLoggingStatus><LoggingEnabled><TargetBucket>"+DestBucket+"</TargetBucket><TargetPrefix>logs/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>", get())
+
+	assert.Equal(t, http.StatusBadRequest, put(`<BucketLoggingStatus><LoggingEnabled></LoggingEnabled></BucketLoggingStatus>`))
+
+	require.Equal(t, http.StatusOK, put(`<BucketLoggingStatus/>`))
+	assert.Nil(t, gateway.GetBucketLogging(TestBucket))
+
+	// Requests without the credentials of the gateway are rejected
+	for _, password := range []string{"", "wrong-secret-key"} {
+		req, err := http.NewRequest(http.MethodPut, server.URL+"?bucket="+TestBucket, strings.NewReader(`<BucketLoggingStatus><LoggingEnabled><TargetBucket>`+DestBucket+`</TargetBucket></LoggingEnabled></BucketLoggingStatus>`))
+		require.NoError(t, err)
+		if password != "" {
+			req.SetBasicAuth(credentials.AccessKey, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	assert.Nil(t, gateway.GetBucketLogging(TestBucket))
+}
+
+func TestPutObjectMirror(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		apiKey := planet.Uplinks[0].APIKey[planet.Satellites[0].ID()]
+		access, err := uplink.RequestAccessWithPassphrase(ctx, planet.Satellites[0].URL(), apiKey.Serialize(), "mirror-passphrase")
+		require.NoError(t, err)
+		serializedAccess, err := access.Serialize()
+		require.NoError(t, err)
+
+		mirror, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(mirror.Close)
+
+		for i, tt := range []struct {
+			bucket   string
+			required bool
+			mirrored bool
+		}{
+			{bucket: "mirror", mirrored: true},
+			{bucket: "mirror", required: true, mirrored: true},
+			{bucket: "missing"},
+			{bucket: "missing", required: true},
+		} {
+			errTag := fmt.Sprintf("%d. %+v", i, tt)
+			bucket := TestBucket + strconv.Itoa(i)
+
+			_, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{
+				Mirror: miniogw.MirrorConfig{
+					Access:   serializedAccess,
+					Bucket:   tt.bucket,
+					Required: tt.required,
+				},
+			})
+			require.NoError(t, err, errTag)
+
+			_, err = m.CreateBucket(ctx, bucket, nil)
+			require.NoError(t, err, errTag)
+			if i == 0 {
+				_, err = mirror.CreateBucket(ctx, "mirror")
+				require.NoError(t, err, errTag)
+			}
+
+			_, err = putObject(ctx, layer, bucket, TestFile, []byte("mirrored"), map[string]string{"key": "value"})
+			if tt.bucket == "missing" && tt.required {
+				// the upload fails if it can't be mirrored
+				require.Error(t, err, errTag)
+				_, err = layer.GetObjectInfo(ctx, bucket, TestFile, minio.ObjectOptions{})
+				assert.Equal(t, minio.ObjectNotFound{Bucket: bucket, Object: TestFile}, err, errTag)
+				continue
+			}
+			require.NoError(t, err, errTag)
+
+			_, err = layer.GetObjectInfo(ctx, bucket, TestFile, minio.ObjectOptions{})
+			require.NoError(t, err, errTag)
+
+			if tt.mirrored {
+				download, err := mirror.DownloadObject(ctx, "mirror", TestFile, nil)
+				require.NoError(t, err, errTag)
+				data, err := ioutil.ReadAll(download)
+				require.NoError(t, err, errTag)
+				require.NoError(t, download.Close(), errTag)
+
+				assert.Equal(t, []byte("mirrored"), data, errTag)
+				assert.Equal(t, "value", download.Info().Custom["key"], errTag)
+
+				_, err = mirror.DeleteObject(ctx, "mirror", TestFile)
+				require.NoError(t, err, errTag)
+			}
+		}
+	})
+}
+
+func TestMultipartAndCopyMirror(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		apiKey := planet.Uplinks[0].APIKey[planet.Satellites[0].ID()]
+		access, err := uplink.RequestAccessWithPassphrase(ctx, planet.Satellites[0].URL(), apiKey.Serialize(), "mirror-passphrase")
+		require.NoError(t, err)
+		serializedAccess, err := access.Serialize()
+		require.NoError(t, err)
+
+		mirror, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(mirror.Close)
+
+		_, err = mirror.CreateBucket(ctx, "mirror")
+		require.NoError(t, err)
+
+		_, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{
+			Mirror: miniogw.MirrorConfig{
+				Access:   serializedAccess,
+				Bucket:   "mirror",
+				Required: true,
+			},
+		})
+		require.NoError(t, err)
+
+		_, err = m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		mirrored := func(key string) []byte {
+			download, err := mirror.DownloadObject(ctx, "mirror", key, nil)
+			require.NoError(t, err, key)
+			data, err := ioutil.ReadAll(download)
+			require.NoError(t, err, key)
+			require.NoError(t, download.Close(), key)
+			assert.Equal(t, "value", download.Info().Custom["key"], key)
+			return data
+		}
+
+		// completed multipart uploads are mirrored
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{"key": "value"}})
+		require.NoError(t, err)
+		var parts []minio.CompletePart
+		for i, data := range []string{"first ", "second"} {
+			hashReader, err := hash.NewReader(bytes.NewReader([]byte(data)), int64(len(data)), "", "", int64(len(data)), true)
+			require.NoError(t, err)
+			part, err := layer.PutObjectPart(ctx, TestBucket, TestFile, uploadID, i+1, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			require.NoError(t, err)
+			parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, parts, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []byte("first second"), mirrored(TestFile))
+
+		// copies are mirrored
+		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []byte("first second"), mirrored(DestFile))
+	})
+}
+
+// xorTransform is a reversible transform, which XORs the content with a key.
+type xorTransform struct {
+	key []byte
+}
+
+func (transform xorTransform) Encode(ctx context.Context, bucket, key string, content io.Reader) (io.Reader, error) {
+	return &xorReader{reader: content, key: transform.key}, nil
+}
+
+func (transform xorTransform) Decode(ctx context.Context, bucket, key string, offset int64, stored io.Reader) (io.Reader, error) {
+	return &xorReader{reader: stored, key: transform.key, offset: offset}, nil
+}
+
+type xorReader struct {
+	reader io.Reader
+	key    []byte
+	offset int64
+}
+
+func (xor *xorReader) Read(p []byte) (n int, err error) {
+	n, err = xor.reader.Read(p)
+	for i := range p[:n] {
+		p[i] ^= xor.key[(xor.offset+int64(i))%int64(len(xor.key))]
+	}
+	xor.offset += int64(n)
+	return n, err
+}
+
+func TestPutObjectTransform(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		_, layer, m, _, err := initEnv(ctx, t, planet, storj.EncAESGCM, miniogw.Config{
+			Transforms: []miniogw.Transform{
+				xorTransform{key: []byte("first")},
+				xorTransform{key: []byte("second key")},
+			},
+		})
+		require.NoError(t, err)
+
+		_, err = m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(1000)
+		info, err := putObject(ctx, layer, TestBucket, TestFile, data, nil)
+		require.NoError(t, err)
+
+		// The ETag and size are the ones of the original content
+		sum := md5.Sum(data)
+		assert.Equal(t, hex.EncodeToString(sum[:]), info.ETag)
+		assert.Equal(t, int64(len(data)), info.Size)
+
+		// The content is read back transparently
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, data, buf.Bytes())
+
+		reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, &minio.HTTPRangeSpec{Start: 123, End: 456}, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		ranged, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, data[123:457], ranged)
+
+		// The stored content is transformed
+		apiKey := planet.Uplinks[0].APIKey[planet.Satellites[0].ID()]
+		access, err := uplink.RequestAccessWithPassphrase(ctx, planet.Satellites[0].URL(), apiKey.Serialize(), "passphrase")
+		require.NoError(t, err)
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+
+		download, err := project.DownloadObject(ctx, TestBucket, TestFile, nil)
+		require.NoError(t, err)
+		stored, err := ioutil.ReadAll(download)
+		require.NoError(t, err)
+		require.NoError(t, download.Close())
+
+		assert.Len(t, stored, len(data))
+		assert.NotEqual(t, data, stored)
+	})
+}
+
+// keyTransform is a reversible transform, which XORs the content with the key
+// of the object, so the stored content depends on the key.
+type keyTransform struct{}
+
+func (keyTransform) Encode(ctx context.Context, bucket, key string, content io.Reader) (io.Reader, error) {
+	return &xorReader{reader: content, key: []byte(key)}, nil
+}
+
+func (keyTransform) Decode(ctx context.Context, bucket, key string, offset int64, stored io.Reader) (io.Reader, error) {
+	return &xorReader{reader: stored, key: []byte(key), offset: offset}, nil
+}
+
+func TestCopyObjectTransform(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		Transforms: []miniogw.Transform{keyTransform{}},
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(1000)
+		putInfo, err := putObject(ctx, layer, TestBucket, TestFile, data, map[string]string{"key1": "value1"})
+		require.NoError(t, err)
+
+		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		// Copy the object preserving and replacing the metadata of the source
+		replaced := srcInfo
+		replaced.UserDefined = map[string]string{"key2": "value2"}
+		for path, info := range map[string]minio.ObjectInfo{DestFile: srcInfo, TestFile2: replaced} {
+			copied, err := layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, path, info, minio.ObjectOptions{}, minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, putInfo.ETag, copied.ETag)
+			assert.Equal(t, "true", copied.UserDefined["s3:transformed"])
+
+			// The copy is decoded with its own key
+			var buf bytes.Buffer
+			err = layer.GetObject(ctx, TestBucket, path, 0, -1, &buf, "", minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, data, buf.Bytes())
+		}
+	})
+}
+
+// shortReadTransform stores the content unchanged, but returns only a part of
+// it when it's read back, as if the download ended early.
+type shortReadTransform struct {
+	missing int64
+}
+
+func (transform shortReadTransform) Encode(ctx context.Context, bucket, key string, content io.Reader) (io.Reader, error) {
+	return content, nil
+}
+
+func (transform shortReadTransform) Decode(ctx context.Context, bucket, key string, offset int64, stored io.Reader) (io.Reader, error) {
+	content, err := ioutil.ReadAll(stored)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content[:int64(len(content))-transform.missing]), nil
+}
+
+func TestGetObjectShortRead(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		Transforms: []miniogw.Transform{shortReadTransform{missing: 10}},
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(1000)
+		_, err = putObject(ctx, layer, TestBucket, TestFile, data, nil)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		assert.True(t, miniogw.ErrIntegrity.Has(err))
+
+		for _, rangeSpec := range []*minio.HTTPRangeSpec{
+			nil,
+			{Start: 100, End: 199},
+			{Start: 100, End: -1},
+		} {
+			errTag := fmt.Sprintf("%+v", rangeSpec)
+
+			reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, rangeSpec, nil, 0, minio.ObjectOptions{})
+			require.NoError(t, err, errTag)
+			_, err = ioutil.ReadAll(reader)
+			assert.True(t, miniogw.ErrIntegrity.Has(err), errTag)
+			require.NoError(t, reader.Close(), errTag)
+		}
+	})
+}
+
+// corruptingTransform stores the content unchanged, but flips the first byte
+// when it's read back, as if the stored content was corrupted.
+type corruptingTransform struct{}
+
+func (transform corruptingTransform) Encode(ctx context.Context, bucket, key string, content io.Reader) (io.Reader, error) {
+	return content, nil
+}
+
+func (transform corruptingTransform) Decode(ctx context.Context, bucket, key string, offset int64, stored io.Reader) (io.Reader, error) {
+	content, err := ioutil.ReadAll(stored)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > 0 {
+		content[0] ^= 0xff
+	}
+	return bytes.NewReader(content), nil
+}
+
+func TestPutObjectVerifyUploads(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		VerifyUploads: true,
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(1000)
+		info, err := putObject(ctx, layer, TestBucket, TestFile, data, nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), info.Size)
+	})
+
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		_, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{})
+		require.NoError(t, err)
+		_, corrupting, _, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{
+			VerifyUploads: true,
+			Transforms:    []miniogw.Transform{corruptingTransform{}},
+		})
+		require.NoError(t, err)
+
+		bucket, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		previous := testrand.BytesInt(1000)
+		_, err = putObject(ctx, layer, TestBucket, TestFile, previous, nil)
+		require.NoError(t, err)
+
+		_, err = putObject(ctx, corrupting, TestBucket, TestFile, testrand.BytesInt(1000), nil)
+		assert.True(t, miniogw.ErrIntegrity.Has(err))
+
+		// Check that the corrupt upload didn't replace the previous object
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, previous, buf.Bytes())
+
+		// and that the temporary object is cleaned up
+		list, err := m.ListObjects(ctx, bucket, storj.ListOptions{Recursive: true, Direction: storj.After})
+		require.NoError(t, err)
+		require.Len(t, list.Items, 1)
+		assert.Equal(t, TestFile, list.Items[0].Path)
+	})
+}
+
+func TestControlKeys(t *testing.T) {
+	key := "a\x00b\nc%d"
+
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		ControlKeys: miniogw.ControlKeysReject,
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		_, err = putObject(ctx, layer, TestBucket, key, []byte("test"), nil)
+		assert.Equal(t, "InvalidArgument", miniov6.ToErrorResponse(err).Code)
+
+		_, err = layer.GetObjectInfo(ctx, TestBucket, key, minio.ObjectOptions{})
+		assert.Equal(t, "InvalidArgument", miniov6.ToErrorResponse(err).Code)
+
+		list, err := layer.ListObjects(ctx, TestBucket, "", "", "", 10)
+		require.NoError(t, err)
+		assert.Empty(t, list.Objects)
+
+		// Other keys are stored as they are
+		_, err = putObject(ctx, layer, TestBucket, "a%00b", []byte("test"), nil)
+		require.NoError(t, err)
+	})
+
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		ControlKeys: miniogw.ControlKeysEncode,
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		_, err = putObject(ctx, layer, TestBucket, key, []byte("test"), nil)
+		require.NoError(t, err)
+
+		// The key is stored encoded
+		_, err = m.GetObject(ctx, testBucketInfo, "a%00b%0Ac%d")
+		require.NoError(t, err)
+
+		info, err := layer.GetObjectInfo(ctx, TestBucket, key, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, key, info.Name)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, key, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "test", buf.String())
+
+		// Listings show the key the client used
+		list, err := layer.ListObjects(ctx, TestBucket, "", "", "", 10)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 1)
+		assert.Equal(t, key, list.Objects[0].Name)
+
+		require.NoError(t, layer.DeleteObject(ctx, TestBucket, key))
+		_, err = layer.GetObjectInfo(ctx, TestBucket, key, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: key}, err)
+
+		// Only "%" that start an escape are encoded, other keys are stored
+		// as they are
+		for object, stored := range map[string]string{
+			"100%.txt": "100%.txt",
+			"a%41b":    "a%41b",
+			"a%25b":    "a%2525b",
+			"a%0Ab":    "a%250Ab",
+		} {
+			_, err = putObject(ctx, layer, TestBucket, object, []byte("test"), nil)
+			require.NoError(t, err)
+
+			_, err = m.GetObject(ctx, testBucketInfo, stored)
+			require.NoError(t, err, object)
+
+			info, err := layer.GetObjectInfo(ctx, TestBucket, object, minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, object, info.Name)
+		}
+
+		list, err = layer.ListObjects(ctx, TestBucket, "", "", "", 10)
+		require.NoError(t, err)
+		var listed []string
+		for _, object := range list.Objects {
+			listed = append(listed, object.Name)
+		}
+		assert.ElementsMatch(t, []string{"100%.txt", "a%41b", "a%25b", "a%0Ab"}, listed)
+	})
+}
+
+func TestKeyRewrites(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		KeyRewrites: []miniogw.KeyRewrite{{Old: "legacy/photos/", New: "photos/"}},
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		_, err = putObject(ctx, layer, TestBucket, "legacy/photos/a.jpg", []byte("test"), nil)
+		require.NoError(t, err)
+		_, err = putObject(ctx, layer, TestBucket, "other/b.txt", []byte("test"), nil)
+		require.NoError(t, err)
+
+		// The keys are stored with the new prefix, other keys as they are
+		_, err = m.GetObject(ctx, testBucketInfo, "photos/a.jpg")
+		require.NoError(t, err)
+		_, err = m.GetObject(ctx, testBucketInfo, "other/b.txt")
+		require.NoError(t, err)
+
+		// Clients address the objects by the old keys
+		info, err := layer.GetObjectInfo(ctx, TestBucket, "legacy/photos/a.jpg", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "legacy/photos/a.jpg", info.Name)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, "legacy/photos/a.jpg", 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "test", buf.String())
+
+		_, err = layer.CopyObject(ctx, TestBucket, "legacy/photos/a.jpg", TestBucket, "legacy/photos/c.jpg", info, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = m.GetObject(ctx, testBucketInfo, "photos/c.jpg")
+		require.NoError(t, err)
+
+		// Listings show the old keys, with their common prefixes
+		list, err := layer.ListObjects(ctx, TestBucket, "", "", "/", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"legacy/", "other/"}, list.Prefixes)
+
+		list, err = layer.ListObjects(ctx, TestBucket, "legacy/", "", "/", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"legacy/photos/"}, list.Prefixes)
+		assert.Empty(t, list.Objects)
+
+		// Listings are sorted by the old keys, so they can be paged
+		_, err = putObject(ctx, layer, TestBucket, "a.txt", []byte("test"), nil)
+		require.NoError(t, err)
+		_, err = putObject(ctx, layer, TestBucket, "z.txt", []byte("test"), nil)
+		require.NoError(t, err)
+
+		var listed []string
+		marker := ""
+		for {
+			list, err := layer.ListObjects(ctx, TestBucket, "", marker, "", 2)
+			require.NoError(t, err)
+			for _, object := range list.Objects {
+				listed = append(listed, object.Name)
+			}
+			if !list.IsTruncated {
+				break
+			}
+			marker = list.NextMarker
+		}
+		assert.Equal(t, []string{"a.txt", "legacy/photos/a.jpg", "legacy/photos/c.jpg", "other/b.txt", "z.txt"}, listed)
+
+		list, err = layer.ListObjects(ctx, TestBucket, "legacy/", "", "", 10)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 2)
+		assert.Equal(t, "legacy/photos/a.jpg", list.Objects[0].Name)
+
+		list, err = layer.ListObjects(ctx, TestBucket, "legacy/photos/", "", "/", 10)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 2)
+		assert.Equal(t, "legacy/photos/a.jpg", list.Objects[0].Name)
+		assert.Equal(t, "legacy/photos/c.jpg", list.Objects[1].Name)
+
+		listV2, err := layer.ListObjectsV2(ctx, TestBucket, "legacy/photos/", "", "/", 1, false, "")
+		require.NoError(t, err)
+		require.Len(t, listV2.Objects, 1)
+		assert.Equal(t, "legacy/photos/a.jpg", listV2.Objects[0].Name)
+		assert.True(t, listV2.IsTruncated)
+
+		listV2, err = layer.ListObjectsV2(ctx, TestBucket, "legacy/photos/", listV2.NextContinuationToken, "/", 1, false, "")
+		require.NoError(t, err)
+		require.Len(t, listV2.Objects, 1)
+		assert.Equal(t, "legacy/photos/c.jpg", listV2.Objects[0].Name)
+
+		// Errors report the old keys
+		require.NoError(t, layer.DeleteObject(ctx, TestBucket, "legacy/photos/a.jpg"))
+		_, err = layer.GetObjectInfo(ctx, TestBucket, "legacy/photos/a.jpg", minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: "legacy/photos/a.jpg"}, err)
+	})
+}
+
+func TestInvalidKeyRewrites(t *testing.T) {
+	for _, rewrites := range [][]miniogw.KeyRewrite{
+		{{Old: "legacy", New: "photos/"}},
+		{{Old: "legacy/", New: "legacy/photos/"}},
+		{{Old: "a/", New: "b/"}, {Old: "b/", New: "a/"}},
+	} {
+		gateway := miniogw.NewStorjGateway(&uplink.Access{}, uplink.Config{}, miniogw.Config{KeyRewrites: rewrites})
+		_, err := gateway.NewGatewayLayer(auth.Credentials{})
+		assert.Error(t, err, rewrites)
+		require.NoError(t, gateway.Close())
+	}
+}
+
+func TestIsolateAccessKeys(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		_, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{IsolateAccessKeys: true})
+		require.NoError(t, err)
+
+		// The layer is shared, the namespace is of the access key each
+		// request is signed with
+		first, other := signedContext(ctx, TestAccessKey), signedContext(ctx, "other-access-key")
+
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		// Requests without an access key are denied
+		anonymous := miniogw.WithRequest(ctx, httptest.NewRequest(http.MethodGet, "/"+TestBucket+"/", nil))
+		_, err = putObject(anonymous, layer, TestBucket, TestFile, []byte("anonymous"), nil)
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: TestFile}, err)
+		_, err = layer.ListObjects(ctx, TestBucket, "", "", "", 0)
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket}, err)
+
+		// Both tenants upload the same key to the shared bucket
+		_, err = putObject(first, layer, TestBucket, TestFile, []byte("first tenant"), nil)
+		require.NoError(t, err)
+		_, err = putObject(first, layer, TestBucket, "dir/"+TestFile2, []byte("first tenant"), nil)
+		require.NoError(t, err)
+		_, err = putObject(other, layer, TestBucket, TestFile, []byte("other tenant"), nil)
+		require.NoError(t, err)
+
+		// The keys are stored in the namespaces of the access keys
+		obj, err := m.GetObject(ctx, testBucketInfo, TestAccessKey+"/"+TestFile)
+		require.NoError(t, err)
+		assert.EqualValues(t, len("first tenant"), obj.Size)
+		_, err = m.GetObject(ctx, testBucketInfo, "other-access-key/"+TestFile)
+		require.NoError(t, err)
+
+		for _, tt := range []struct {
+			ctx  context.Context
+			data string
+		}{
+			{ctx: first, data: "first tenant"},
+			{ctx: other, data: "other tenant"},
+		} {
+			info, err := layer.GetObjectInfo(tt.ctx, TestBucket, TestFile, minio.ObjectOptions{})
+			require.NoError(t, err, tt.data)
+			assert.Equal(t, TestFile, info.Name, tt.data)
+
+			var buf bytes.Buffer
+			err = layer.GetObject(tt.ctx, TestBucket, TestFile, 0, info.Size, &buf, "", minio.ObjectOptions{})
+			require.NoError(t, err, tt.data)
+			assert.Equal(t, tt.data, buf.String(), tt.data)
+		}
+
+		// Keys of other tenants can't be addressed
+		_, err = layer.GetObjectInfo(other, TestBucket, "dir/"+TestFile2, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: "dir/" + TestFile2}, err)
+		_, err = layer.GetObjectInfo(other, TestBucket, "../"+TestAccessKey+"/"+TestFile, minio.ObjectOptions{})
+		assert.Error(t, err)
+		err = layer.DeleteObject(other, TestBucket, "dir/"+TestFile2)
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: "dir/" + TestFile2}, err)
+
+		// Listings only contain the keys of the tenant
+		list, err := layer.ListObjects(first, TestBucket, "", "", "/", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"dir/"}, list.Prefixes)
+		require.Len(t, list.Objects, 1)
+		assert.Equal(t, TestFile, list.Objects[0].Name)
+
+		listV2, err := layer.ListObjectsV2(other, TestBucket, "", "", "", 0, false, "")
+		require.NoError(t, err)
+		assert.Empty(t, listV2.Prefixes)
+		require.Len(t, listV2.Objects, 1)
+		assert.Equal(t, TestFile, listV2.Objects[0].Name)
+
+		list, err = layer.ListObjects(other, TestBucket, "dir/", "", "", 0)
+		require.NoError(t, err)
+		assert.Empty(t, list.Objects)
+	})
+}
+
+func TestGetObjectInfo(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		// Check the error when getting an object from a bucket with empty name
+		_, err := layer.GetObjectInfo(ctx, "", "", minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNameInvalid{}, err)
+
+		// Check the error when getting an object from non-existing bucket
+		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+
+		// Create the bucket using the Metainfo API
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		assert.NoError(t, err)
+
+		// Check the error when getting an object with empty name
+		_, err = layer.GetObjectInfo(ctx, TestBucket, "", minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+
+		// Check the error when getting a non-existing object
+		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+
+		// Create the object using the Metainfo API
+		createInfo := kvmetainfo.CreateObject{
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"key1": "value1", "key2": "value2"},
+		}
+		obj, err := createFile(ctx, m, strms, testBucketInfo, TestFile, &createInfo, []byte("test"))
+		assert.NoError(t, err)
+
+		// Get the object info using the Minio API
+		info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, TestFile, info.Name)
+			assert.Equal(t, TestBucket, info.Bucket)
+			assert.False(t, info.IsDir)
+			assert.Equal(t, obj.Created, info.ModTime)
+			assert.Equal(t, obj.Size, info.Size)
+			assert.Equal(t, hex.EncodeToString(obj.Checksum), info.ETag)
+			assert.Equal(t, createInfo.ContentType, info.ContentType)
+			assert.Equal(t, createInfo.Metadata, info.UserDefined)
+		}
+	})
+}
+
+func TestGetObjectNInfo(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		// Check the error when getting an object from a bucket with empty name
+		_, err := layer.GetObjectNInfo(ctx, "", "", nil, nil, 0, minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNameInvalid{}, err)
+
+		// Check the error when getting an object from non-existing bucket
+		_, err = layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+
+		// Create the bucket using the Metainfo API
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		assert.NoError(t, err)
+
+		// Check the error when getting an object with empty name
+		_, err = layer.GetObjectNInfo(ctx, TestBucket, "", nil, nil, 0, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+
+		// Check the error when getting a non-existing object
+		_, err = layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+
+		// Create the object using the Metainfo API
+		createInfo := kvmetainfo.CreateObject{
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"key1": "value1", "key2": "value2"},
+		}
+		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile, &createInfo, []byte("abcdef"))
+		assert.NoError(t, err)
+
+		for i, tt := range []struct {
+			rangeSpec *minio.HTTPRangeSpec
+			substr    string
+			err       error
+		}{
+			{rangeSpec: nil, substr: "abcdef"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 0}, substr: "a"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 3, End: 3}, substr: "d"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: -1}, substr: "abcdef"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 3, End: -1}, substr: "def"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 5}, substr: "abcdef"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 4}, substr: "abcde"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 3}, substr: "abcd"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 1, End: 4}, substr: "bcde"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 2, End: 5}, substr: "cdef"},
+			{rangeSpec: &minio.HTTPRangeSpec{IsSuffixLength: true, Start: 0}, substr: ""},
+			{rangeSpec: &minio.HTTPRangeSpec{IsSuffixLength: true, Start: -2}, substr: "ef"},
+			{rangeSpec: &minio.HTTPRangeSpec{IsSuffixLength: true, Start: -100}, substr: "abcdef"},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: 7}, substr: "", err: minio.InvalidRange{OffsetBegin: 0, OffsetEnd: 7, ResourceSize: 6}},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: -1, End: 3}, substr: "", err: minio.InvalidRange{OffsetBegin: -1, OffsetEnd: 3, ResourceSize: 6}},
+			{rangeSpec: &minio.HTTPRangeSpec{Start: 0, End: -2}, substr: "", err: errs.New("Unexpected range specification case")},
+			{rangeSpec: &minio.HTTPRangeSpec{IsSuffixLength: true, Start: 1}, substr: "", err: errs.New("Unexpected range specification case")},
+		} {
+			errTag := fmt.Sprintf("%d. %v", i, tt)
+
+			// Get the object info using the Minio API
+			reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, tt.rangeSpec, nil, 0, minio.ObjectOptions{})
+
+			if tt.err != nil {
+				assert.EqualError(t, err, tt.err.Error(), errTag)
+			} else if assert.NoError(t, err) {
+				data, err := ioutil.ReadAll(reader)
+				assert.NoError(t, err, errTag)
+
+				err = reader.Close()
+				assert.NoError(t, err, errTag)
+
+				assert.Equal(t, tt.substr, string(data), errTag)
+			}
+		}
+	})
+}
+
+func TestGetObjectNInfoIfRange(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		info, err := putObject(ctx, layer, TestBucket, TestFile, []byte("abcdef"), nil)
+		require.NoError(t, err)
+
+		// Resume the download while the object is unchanged
+		header := http.Header{}
+		header.Set("If-Range", `"`+info.ETag+`"`)
+		rangeSpec := &minio.HTTPRangeSpec{Start: 3, End: -1}
+
+		reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, rangeSpec, header, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, "def", string(data))
+		assert.Equal(t, &minio.HTTPRangeSpec{Start: 3, End: -1}, rangeSpec)
+
+		// Overwrite the object in the middle of the download
+		_, err = putObject(ctx, layer, TestBucket, TestFile, []byte("ghijklmn"), nil)
+		require.NoError(t, err)
+
+		// Resuming must return the full new object
+		reader, err = layer.GetObjectNInfo(ctx, TestBucket, TestFile, rangeSpec, header, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		data, err = ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, "ghijklmn", string(data))
+		assert.Equal(t, &minio.HTTPRangeSpec{Start: 0, End: -1}, rangeSpec)
+	})
+}
+
+// TestRequestHandlerRegistered fails if the gateway can't add its request
+// handler to minio, which it links to by name, e.g. after a minio update
+// renamed the handlers.
+func TestRequestHandlerRegistered(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		require.NotPanics(t, func() {
+			gateway, _, _, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{})
+			require.NoError(t, err)
+			require.NoError(t, gateway.Close())
+		}, "the go:linkname of minio's global handlers is broken")
+	})
+}
+
+func TestGetObjectNInfoWebsiteTag(t *testing.T) {
+	config := miniogw.Config{Website: true, WebsiteTag: "public=true"}
+	runTestWithConfig(t, storj.EncNull, config, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		_, err = putObject(ctx, layer, TestBucket, TestFile, []byte("public"), map[string]string{"X-Amz-Tagging": "public=true"})
+		require.NoError(t, err)
+		_, err = putObject(ctx, layer, TestBucket, TestFile2, []byte("private"), nil)
+		require.NoError(t, err)
+		_, err = putObject(ctx, layer, TestBucket, TestFile3, []byte("other"), map[string]string{"X-Amz-Tagging": "public=false&other=true"})
+		require.NoError(t, err)
+
+		tags, err := layer.GetObjectTag(ctx, TestBucket, TestFile)
+		require.NoError(t, err)
+		assert.Equal(t, "public=true", tags.String())
+
+		anonymous := httptest.NewRequest(http.MethodGet, "/"+TestBucket+"/", nil)
+		presigned := httptest.NewRequest(http.MethodGet, "/"+TestBucket+"/?X-Amz-Credential=key%2F20200101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Signature=...", nil)
+		authenticated := httptest.NewRequest(http.MethodGet, "/"+TestBucket+"/", nil)
+		authenticated.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=key/20200101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=...")
+
+		for i, tt := range []struct {
+			object  string
+			request *http.Request
+			allowed bool
+		}{
+			{object: TestFile, request: anonymous, allowed: true},
+			{object: TestFile2, request: anonymous, allowed: false},
+			{object: TestFile3, request: anonymous, allowed: false},
+			{object: TestFile, request: presigned, allowed: true},
+			{object: TestFile2, request: presigned, allowed: true},
+			{object: TestFile3, request: presigned, allowed: true},
+			{object: TestFile, request: authenticated, allowed: true},
+			{object: TestFile2, request: authenticated, allowed: true},
+			{object: TestFile3, request: authenticated, allowed: true},
+		} {
+			errTag := fmt.Sprintf("%d. %s %s", i, tt.object, tt.request.URL)
+			ctx := miniogw.WithRequest(ctx, tt.request)
+
+			// all reads are checked
+			reader, err := layer.GetObjectNInfo(ctx, TestBucket, tt.object, nil, tt.request.Header, 0, minio.ObjectOptions{})
+			if err == nil {
+				assert.NoError(t, reader.Close(), errTag)
+			}
+			_, infoErr := layer.GetObjectInfo(ctx, TestBucket, tt.object, minio.ObjectOptions{})
+			getErr := layer.GetObject(ctx, TestBucket, tt.object, 0, -1, ioutil.Discard, "", minio.ObjectOptions{})
+
+			for _, err := range []error{err, infoErr, getErr} {
+				if tt.allowed {
+					assert.NoError(t, err, errTag)
+				} else {
+					assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: tt.object}, err, errTag)
+				}
+			}
+		}
+	})
+}
+
+func TestGetObjectNInfoEncryptionPath(t *testing.T) {
+	runTestWithConfig(t, storj.EncAESGCM, miniogw.Config{EncryptionPaths: []string{TestBucket + "/a/b/"}}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		_, err = putObject(ctx, layer, TestBucket, "a/b/"+TestFile, []byte("encrypted under a/b/"), nil)
+		require.NoError(t, err)
+
+		read := func(path string) ([]byte, error) {
+			header := http.Header{}
+			header.Set("X-Storj-Encryption-Path", path)
+			reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, header, 0, minio.ObjectOptions{})
+			if err != nil {
+				return nil, err
+			}
+			defer func() { _ = reader.Close() }()
+			return ioutil.ReadAll(reader)
+		}
+
+		data, err := read("a/b/")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("encrypted under a/b/"), data)
+
+		// Paths that are not configured are rejected
+		_, err = read("a/")
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: TestFile}, err)
+
+		// Without the header the object is read under its own key
+		_, err = read("")
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+
+		// The path applies to all requests addressing the object
+		request := httptest.NewRequest(http.MethodHead, "/"+TestBucket+"/"+TestFile, nil)
+		request.Header.Set("X-Storj-Encryption-Path", "a/b/")
+		pathCtx := miniogw.WithRequest(ctx, request)
+
+		info, err := layer.GetObjectInfo(pathCtx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, TestFile, info.Name)
+		assert.Equal(t, int64(len("encrypted under a/b/")), info.Size)
+
+		var buf bytes.Buffer
+		require.NoError(t, layer.GetObject(pathCtx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{}))
+		assert.Equal(t, "encrypted under a/b/", buf.String())
+
+		_, err = layer.GetObjectTag(pathCtx, TestBucket, TestFile)
+		require.NoError(t, err)
+
+		_, err = layer.CopyObject(pathCtx, TestBucket, TestFile, TestBucket, TestFile2, info, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		buf.Reset()
+		require.NoError(t, layer.GetObject(ctx, TestBucket, TestFile2, 0, -1, &buf, "", minio.ObjectOptions{}))
+		assert.Equal(t, "encrypted under a/b/", buf.String())
+
+		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+	})
+}
+
+func TestGetObject(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		// Check the error when getting an object from a bucket with empty name
+		err := layer.GetObject(ctx, "", "", 0, 0, nil, "", minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNameInvalid{}, err)
+
+		// Check the error when getting an object from non-existing bucket
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, 0, nil, "", minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+
+		// Create the bucket using the Metainfo API
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		assert.NoError(t, err)
+
+		// Check the error when getting an object with empty name
+		err = layer.GetObject(ctx, TestBucket, "", 0, 0, nil, "", minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+
+		// Check the error when getting a non-existing object
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, 0, nil, "", minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+
+		// Create the object using the Metainfo API
+		createInfo := kvmetainfo.CreateObject{
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"key1": "value1", "key2": "value2"},
+		}
+		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile, &createInfo, []byte("abcdef"))
+		assert.NoError(t, err)
+
+		for i, tt := range []struct {
+			offset, length int64
+			substr         string
+			err            error
+		}{
+			{offset: 0, length: 0, substr: ""},
+			{offset: 3, length: 0, substr: ""},
+			{offset: 0, length: -1, substr: "abcdef"},
+			{offset: 0, length: 6, substr: "abcdef"},
+			{offset: 0, length: 5, substr: "abcde"},
+			{offset: 0, length: 4, substr: "abcd"},
+			{offset: 1, length: 4, substr: "bcde"},
+			{offset: 2, length: 4, substr: "cdef"},
+			{offset: 0, length: 7, substr: "", err: minio.InvalidRange{OffsetBegin: 0, OffsetEnd: 7, ResourceSize: 6}},
+			{offset: -1, length: 7, substr: "", err: minio.InvalidRange{OffsetBegin: -1, OffsetEnd: 6, ResourceSize: 6}},
+			{offset: 0, length: -2, substr: "", err: minio.InvalidRange{OffsetBegin: 0, OffsetEnd: -2, ResourceSize: 6}},
+		} {
+			errTag := fmt.Sprintf("%d. %+v", i, tt)
+
+			var buf bytes.Buffer
+
+			// Get the object info using the Minio API
+			err = layer.GetObject(ctx, TestBucket, TestFile, tt.offset, tt.length, &buf, "", minio.ObjectOptions{})
+
+			if tt.err != nil {
+				assert.Equal(t, tt.err, err, errTag)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tt.substr, buf.String(), errTag)
+			}
+		}
+	})
+}
+
+func TestCopyObject(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		// Check the error when copying an object from a bucket with empty name
+		_, err := layer.CopyObject(ctx, "", TestFile, DestBucket, DestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNameInvalid{}, err)
+
+		// Check the error when copying an object from non-existing bucket
+		_, err = layer.CopyObject(ctx, TestBucket, TestFile, DestBucket, DestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+
+		// Create the source bucket using the Metainfo API
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		assert.NoError(t, err)
+
+		// Check the error when copying an object with empty name
+		_, err = layer.CopyObject(ctx, TestBucket, "", DestBucket, DestFile, minio.ObjectInfo{}, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+
+		// Create the source object using the Metainfo API
+		createInfo := kvmetainfo.CreateObject{
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"key1": "value1", "key2": "value2"},
+		}
+		obj, err := createFile(ctx, m, strms, testBucketInfo, TestFile, &createInfo, []byte("test"))
+		assert.NoError(t, err)
+
+		// Get the source object info using the Minio API
+		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		assert.NoError(t, err)
+
+		// Check the error when copying an object to a bucket with empty name
+		_, err = layer.CopyObject(ctx, TestBucket, TestFile, "", DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNameInvalid{}, err)
+
+		// Check the error when copying an object to a non-existing bucket
+		_, err = layer.CopyObject(ctx, TestBucket, TestFile, DestBucket, DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNotFound{Bucket: DestBucket}, err)
+
+		// Create the destination bucket using the Metainfo API
+		destBucketInfo, err := m.CreateBucket(ctx, DestBucket, nil)
+		assert.NoError(t, err)
+
+		// Copy the object using the Minio API
+		info, err := layer.CopyObject(ctx, TestBucket, TestFile, DestBucket, DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, DestFile, info.Name)
+			assert.Equal(t, DestBucket, info.Bucket)
+			assert.False(t, info.IsDir)
+			assert.True(t, info.ModTime.Sub(obj.Modified) < 1*time.Minute)
+			assert.Equal(t, obj.Size, info.Size)
+			assert.Equal(t, createInfo.ContentType, info.ContentType)
+			assert.Equal(t, createInfo.Metadata, info.UserDefined)
+		}
 
 		// Check that the destination object is uploaded using the Metainfo API
 		obj, err = m.GetObject(ctx, destBucketInfo, DestFile)
@@ -494,6 +1869,151 @@ func TestCopyObject(t *testing.T) {
 	})
 }
 
+func TestCopyObjectZeroBytes(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		const emptyETag = "d41d8cd98f00b204e9800998ecf8427e"
+
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		// Put a zero-byte object using the Minio API
+		_, err = putObject(ctx, layer, TestBucket, TestFile, nil, map[string]string{"key1": "value1"})
+		require.NoError(t, err)
+
+		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Zero(t, srcInfo.Size)
+		assert.Equal(t, emptyETag, srcInfo.ETag)
+
+		// Copy the object preserving the metadata of the source
+		info, err := layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, DestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Zero(t, info.Size)
+		assert.Equal(t, emptyETag, info.ETag)
+		assert.Equal(t, "value1", info.UserDefined["key1"])
+
+		// Copy the object replacing the metadata of the source
+		replaced := srcInfo
+		replaced.UserDefined = map[string]string{"key2": "value2"}
+		info, err = layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, TestFile2, replaced, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Zero(t, info.Size)
+		assert.Equal(t, emptyETag, info.ETag)
+		assert.Equal(t, "value2", info.UserDefined["key2"])
+		assert.NotContains(t, info.UserDefined, "key1")
+
+		// Check that the destinations are empty and keep the ETag
+		for _, object := range []string{DestFile, TestFile2} {
+			destInfo, err := layer.GetObjectInfo(ctx, TestBucket, object, minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Zero(t, destInfo.Size)
+			assert.Equal(t, emptyETag, destInfo.ETag)
+
+			var buffer bytes.Buffer
+			err = layer.GetObject(ctx, TestBucket, object, 0, -1, &buffer, "", minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Zero(t, buffer.Len())
+		}
+
+		// Copy the object onto itself, i.e. a metadata-only copy
+		info, err = layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, TestFile, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Zero(t, info.Size)
+		assert.Equal(t, emptyETag, info.ETag)
+	})
+}
+
+func TestCopyObjectReplaceMetadata(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(1000)
+		putInfo, err := putObject(ctx, layer, TestBucket, TestFile, data, map[string]string{"key1": "value1"})
+		require.NoError(t, err)
+
+		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		// Copy the object onto itself replacing its metadata
+		replaced := srcInfo
+		replaced.UserDefined = map[string]string{"key2": "value2"}
+		info, err := layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, TestFile, replaced, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, putInfo.ETag, info.ETag)
+		assert.Equal(t, "value2", info.UserDefined["key2"])
+		assert.NotContains(t, info.UserDefined, "key1")
+
+		// Check that the metadata is replaced and the content and ETag kept
+		info, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, putInfo.ETag, info.ETag)
+		assert.Equal(t, int64(len(data)), info.Size)
+		assert.Equal(t, "value2", info.UserDefined["key2"])
+		assert.NotContains(t, info.UserDefined, "key1")
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, data, buf.Bytes())
+
+		// The temporary copy is removed
+		list, err := layer.ListObjects(ctx, TestBucket, "", "", "", 10)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 1)
+		assert.Equal(t, TestFile, list.Objects[0].Name)
+		assert.Empty(t, list.Prefixes)
+	})
+}
+
+func TestCopyObjectDedup(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{DedupBucket: "dedup"}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+		_, err = m.CreateBucket(ctx, "dedup", nil)
+		require.NoError(t, err)
+
+		data := []byte("deduplicated content")
+		sum := sha256.Sum256(data)
+		hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", hex.EncodeToString(sum[:]), int64(len(data)), true)
+		require.NoError(t, err)
+		putInfo, err := layer.PutObject(ctx, TestBucket, TestFile, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{UserDefined: map[string]string{"key1": "value1"}})
+		require.NoError(t, err)
+
+		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		// Copy the object preserving and replacing the metadata of the source
+		replaced := srcInfo
+		replaced.UserDefined = map[string]string{"key2": "value2"}
+		for path, info := range map[string]minio.ObjectInfo{DestFile: srcInfo, TestFile2: replaced} {
+			copied, err := layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, path, info, minio.ObjectOptions{}, minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), copied.Size)
+			assert.Equal(t, putInfo.ETag, copied.ETag)
+
+			// The copy references the same content
+			obj, err := m.GetObject(ctx, testBucketInfo, path)
+			require.NoError(t, err)
+			assert.Zero(t, obj.Size)
+
+			var buf bytes.Buffer
+			err = layer.GetObject(ctx, TestBucket, path, 0, -1, &buf, "", minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, data, buf.Bytes())
+		}
+
+		info, err := layer.GetObjectInfo(ctx, TestBucket, DestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "value1", info.UserDefined["key1"])
+
+		info, err = layer.GetObjectInfo(ctx, TestBucket, TestFile2, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "value2", info.UserDefined["key2"])
+		assert.NotContains(t, info.UserDefined, "key1")
+	})
+}
+
 func TestDeleteObject(t *testing.T) {
 	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
 		// Check the error when deleting an object from a bucket with empty name
@@ -542,42 +2062,677 @@ func TestDeleteObjects(t *testing.T) {
 		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
 		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, errors[0])
 
-		// Create the bucket using the Metainfo API
-		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		// Create the bucket using the Metainfo API
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		assert.NoError(t, err)
+
+		// Check the error when deleting an object with empty name
+		errors, err = layer.DeleteObjects(ctx, TestBucket, []string{""})
+		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
+		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, errors[0])
+
+		// Check the error when deleting a non-existing object
+		errors, err = layer.DeleteObjects(ctx, TestBucket, []string{TestFile})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, errors[0])
+
+		// Create the 3 objects using the Metainfo API
+		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile, nil, nil)
+		assert.NoError(t, err)
+		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile2, nil, nil)
+		assert.NoError(t, err)
+		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile3, nil, nil)
+		assert.NoError(t, err)
+
+		// Delete the 1st and the 3rd object using the Minio API
+		errors, err = layer.DeleteObjects(ctx, TestBucket, []string{TestFile, TestFile3})
+		assert.NoError(t, err)
+		require.Len(t, errors, 2)
+		assert.NoError(t, errors[0])
+		assert.NoError(t, errors[1])
+
+		// Check using the Metainfo API that the 1st and the 3rd objects are deleted, but the 2nd is still there
+		_, err = m.GetObject(ctx, testBucketInfo, TestFile)
+		assert.True(t, storj.ErrObjectNotFound.Has(err))
+		_, err = m.GetObject(ctx, testBucketInfo, TestFile2)
+		assert.NoError(t, err)
+		_, err = m.GetObject(ctx, testBucketInfo, TestFile3)
+		assert.True(t, storj.ErrObjectNotFound.Has(err))
+	})
+}
+
+func TestGetObjectReplicaFailover(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		apiKey := planet.Uplinks[0].APIKey[planet.Satellites[0].ID()]
+		access, err := uplink.RequestAccessWithPassphrase(ctx, planet.Satellites[0].URL(), apiKey.Serialize(), "passphrase")
+		require.NoError(t, err)
+		serializedAccess, err := access.Serialize()
+		require.NoError(t, err)
+
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+
+		_, err = project.CreateBucket(ctx, TestBucket)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(1000)
+		upload, err := project.UploadObject(ctx, TestBucket, TestFile, nil)
+		require.NoError(t, err)
+		_, err = upload.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, upload.Commit())
+
+		// Reads from the primary project fail, as its access can't read
+		primary, err := access.Share(uplink.Permission{AllowUpload: true, AllowDelete: true})
+		require.NoError(t, err)
+
+		withoutReplica, err := miniogw.NewStorjGateway(primary, uplink.Config{}, miniogw.Config{}).NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return withoutReplica.Shutdown(ctx) })
+
+		_, err = withoutReplica.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.Error(t, err)
+
+		for _, preferred := range []bool{false, true} {
+			errTag := fmt.Sprintf("preferred: %v", preferred)
+
+			layer, err := miniogw.NewStorjGateway(primary, uplink.Config{}, miniogw.Config{
+				Replica: miniogw.ReplicaConfig{
+					Access:    serializedAccess,
+					Preferred: preferred,
+				},
+			}).NewGatewayLayer(auth.Credentials{})
+			require.NoError(t, err, errTag)
+
+			// The replica serves the reads
+			info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+			require.NoError(t, err, errTag)
+			assert.Equal(t, int64(len(data)), info.Size, errTag)
+
+			var buf bytes.Buffer
+			err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+			require.NoError(t, err, errTag)
+			assert.Equal(t, data, buf.Bytes(), errTag)
+
+			reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, &minio.HTTPRangeSpec{Start: 10, End: 19}, nil, 0, minio.ObjectOptions{})
+			require.NoError(t, err, errTag)
+			ranged, err := ioutil.ReadAll(reader)
+			require.NoError(t, err, errTag)
+			require.NoError(t, reader.Close(), errTag)
+			assert.Equal(t, data[10:20], ranged, errTag)
+
+			list, err := layer.ListObjects(ctx, TestBucket, "", "", "", 0)
+			require.NoError(t, err, errTag)
+			require.Len(t, list.Objects, 1, errTag)
+			assert.Equal(t, TestFile, list.Objects[0].Name, errTag)
+
+			// Errors of the replica are returned as they are
+			_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile2, minio.ObjectOptions{})
+			assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile2}, err, errTag)
+
+			require.NoError(t, layer.Shutdown(ctx), errTag)
+		}
+	})
+}
+
+func TestReadOnlyAccess(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		apiKey := planet.Uplinks[0].APIKey[planet.Satellites[0].ID()]
+		access, err := uplink.RequestAccessWithPassphrase(ctx, planet.Satellites[0].URL(), apiKey.Serialize(), "passphrase")
+		require.NoError(t, err)
+
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+
+		_, err = project.CreateBucket(ctx, TestBucket)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(100)
+		upload, err := project.UploadObject(ctx, TestBucket, TestFile, nil)
+		require.NoError(t, err)
+		_, err = upload.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, upload.Commit())
+
+		// A gateway with full access isn't read-only
+		assert.False(t, miniogw.NewStorjGateway(access, uplink.Config{}, miniogw.Config{}).ReadOnly())
+
+		readOnly, err := access.Share(uplink.Permission{AllowDownload: true, AllowList: true})
+		require.NoError(t, err)
+
+		gateway := miniogw.NewStorjGateway(readOnly, uplink.Config{}, miniogw.Config{})
+		assert.True(t, gateway.ReadOnly())
+
+		layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		// Writes are denied
+		_, err = putObject(ctx, layer, TestBucket, TestFile2, data, nil)
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: TestFile2}, err)
+
+		err = layer.DeleteObject(ctx, TestBucket, TestFile)
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: TestFile}, err)
+
+		err = layer.MakeBucketWithLocation(ctx, DestBucket, "")
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: DestBucket}, err)
+
+		_, err = layer.NewMultipartUpload(ctx, TestBucket, TestFile2, minio.ObjectOptions{})
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: TestFile2}, err)
+
+		// Reads are served
+		info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), info.Size)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, data, buf.Bytes())
+
+		list, err := layer.ListObjects(ctx, TestBucket, "", "", "", 0)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 1)
+		assert.Equal(t, TestFile, list.Objects[0].Name)
+
+		// A gateway follows the time limits of the access grant
+		later, err := access.Share(uplink.Permission{
+			AllowDownload: true, AllowUpload: true, AllowList: true, AllowDelete: true,
+			NotBefore: time.Now().Add(2 * time.Second),
+		})
+		require.NoError(t, err)
+
+		gateway = miniogw.NewStorjGateway(later, uplink.Config{}, miniogw.Config{})
+		assert.True(t, gateway.ReadOnly())
+
+		laterLayer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return laterLayer.Shutdown(ctx) })
+
+		time.Sleep(3 * time.Second)
+		_, err = putObject(ctx, laterLayer, TestBucket, TestFile2, data, nil)
+		require.NoError(t, err)
+		assert.False(t, gateway.ReadOnly())
+
+		expiring, err := access.Share(uplink.Permission{
+			AllowDownload: true, AllowUpload: true, AllowList: true, AllowDelete: true,
+			NotAfter: time.Now().Add(2 * time.Second),
+		})
+		require.NoError(t, err)
+
+		gateway = miniogw.NewStorjGateway(expiring, uplink.Config{}, miniogw.Config{})
+		assert.False(t, gateway.ReadOnly())
+
+		expiringLayer, err := gateway.NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return expiringLayer.Shutdown(ctx) })
+
+		time.Sleep(3 * time.Second)
+		_, err = putObject(ctx, expiringLayer, TestBucket, TestFile3, data, nil)
+		require.Error(t, err)
+		assert.True(t, gateway.ReadOnly())
+
+		_, err = putObject(ctx, expiringLayer, TestBucket, TestFile3, data, nil)
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: TestFile3}, err)
+	})
+}
+
+func TestConcurrencyFairness(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		config := miniogw.Config{Concurrency: miniogw.ConcurrencyConfig{Limit: 2, Strict: strict}}
+		runTestWithConfig(t, storj.EncNull, config, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+			_, err := m.CreateBucket(ctx, TestBucket, nil)
+			require.NoError(t, err)
+			_, err = m.CreateBucket(ctx, DestBucket, nil)
+			require.NoError(t, err)
+
+			_, err = putObject(ctx, layer, DestBucket, TestFile, []byte("test"), nil)
+			require.NoError(t, err)
+
+			data := testrand.BytesInt(100)
+
+			// Saturate the limit with uploads to one bucket, whose content
+			// is held back until they are released
+			var wg sync.WaitGroup
+			var uploads []*io.PipeWriter
+			errors := make(chan error, 3)
+			for i := 0; i < 3; i++ {
+				reader, writer := io.Pipe()
+				uploads = append(uploads, writer)
+
+				hashReader, err := hash.NewReader(reader, int64(len(data)), "", "", int64(len(data)), true)
+				require.NoError(t, err)
+
+				wg.Add(1)
+				go func(object string) {
+					defer wg.Done()
+					_, err := layer.PutObject(ctx, TestBucket, object, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{UserDefined: map[string]string{}})
+					errors <- err
+				}(fmt.Sprintf("%s-%d", TestFile, i))
+
+				if i < 2 {
+					// the upload holds a slot once it reads its content
+					_, err = writer.Write(data[:1])
+					require.NoError(t, err)
+				}
+			}
+
+			stat := make(chan error, 1)
+			go func() {
+				_, err := layer.GetObjectInfo(ctx, DestBucket, TestFile, minio.ObjectOptions{})
+				stat <- err
+			}()
+
+			select {
+			case err := <-stat:
+				t.Fatalf("request served while the limit is saturated: %v", err)
+			case <-time.After(500 * time.Millisecond):
+			}
+
+			// Release one upload, the other bucket gets the slot before the
+			// upload waiting longer
+			_, err = uploads[0].Write(data[1:])
+			require.NoError(t, err)
+			require.NoError(t, uploads[0].Close())
+
+			select {
+			case err := <-stat:
+				require.NoError(t, err)
+			case <-time.After(10 * time.Second):
+				t.Fatal("request to the other bucket not served")
+			}
+
+			// Release the remaining uploads, the last one didn't start yet
+			go func() {
+				_, err := uploads[1].Write(data[1:])
+				_ = uploads[1].CloseWithError(err)
+			}()
+			go func() {
+				_, err := uploads[2].Write(data)
+				_ = uploads[2].CloseWithError(err)
+			}()
+
+			wg.Wait()
+			close(errors)
+			for err := range errors {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBucketAlias(t *testing.T) {
+	const alias = "old-bucket"
+
+	config := miniogw.Config{BucketAliases: map[string]string{alias: TestBucket}}
+	runTestWithConfig(t, storj.EncNull, config, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(100)
+		_, err = putObject(ctx, layer, TestBucket, TestFile, data, nil)
+		require.NoError(t, err)
+
+		// HEAD on the alias returns the object stored under the bucket
+		info, err := layer.GetObjectInfo(ctx, alias, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, alias, info.Bucket)
+		assert.Equal(t, TestFile, info.Name)
+		assert.Equal(t, int64(len(data)), info.Size)
+
+		// GET on the alias returns the content
+		reader, err := layer.GetObjectNInfo(ctx, alias, TestFile, nil, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, reader.Close())
+		require.NoError(t, err)
+		assert.Equal(t, data, content)
+		assert.Equal(t, alias, reader.ObjInfo.Bucket)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, alias, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, data, buf.Bytes())
+
+		list, err := layer.ListObjects(ctx, alias, "", "", "", 0)
+		require.NoError(t, err)
+		require.Len(t, list.Objects, 1)
+		assert.Equal(t, TestFile, list.Objects[0].Name)
+		assert.Equal(t, alias, list.Objects[0].Bucket)
+
+		// Errors refer to the alias
+		_, err = layer.GetObjectInfo(ctx, alias, TestFile2, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: alias, Object: TestFile2}, err)
+	})
+}
+
+func TestObjectAliases(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{ObjectAliases: true}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(100)
+		_, err = putObject(ctx, layer, TestBucket, TestFile, data, nil)
+		require.NoError(t, err)
+
+		// Create an alias to the object
+		_, err = putObject(ctx, layer, TestBucket, TestFile2, nil, map[string]string{"X-Amz-Meta-Alias-Target": TestFile})
+		require.NoError(t, err)
+
+		// GET on the alias returns the content of the target
+		reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile2, nil, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, reader.Close())
+		require.NoError(t, err)
+		assert.Equal(t, data, content)
+		assert.Equal(t, TestFile2, reader.ObjInfo.Name)
+		assert.Equal(t, int64(len(data)), reader.ObjInfo.Size)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile2, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, data, buf.Bytes())
+
+		info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile2, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, TestFile2, info.Name)
+		assert.Equal(t, int64(len(data)), info.Size)
+
+		// Deleting the alias doesn't delete the target
+		require.NoError(t, layer.DeleteObject(ctx, TestBucket, TestFile2))
+
+		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile2, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile2}, err)
+
+		info, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), info.Size)
+	})
+}
+
+func TestObjectAliasTargets(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		ObjectAliases:     true,
+		IsolateAccessKeys: true,
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		first, other := signedContext(ctx, TestAccessKey), signedContext(ctx, "other-access-key")
+
+		_, err = putObject(first, layer, TestBucket, TestFile, []byte("first tenant"), nil)
+		require.NoError(t, err)
+
+		// The target is in the namespace of the alias
+		_, err = putObject(other, layer, TestBucket, TestFile2, nil, map[string]string{"X-Amz-Meta-Alias-Target": TestFile})
+		require.NoError(t, err)
+		_, err = layer.GetObjectInfo(other, TestBucket, TestFile2, minio.ObjectOptions{})
+		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile2}, err)
+
+		_, err = putObject(other, layer, TestBucket, TestFile2, nil, map[string]string{"X-Amz-Meta-Alias-Target": "../" + TestAccessKey + "/" + TestFile})
+		require.NoError(t, err)
+		_, err = layer.GetObjectInfo(other, TestBucket, TestFile2, minio.ObjectOptions{})
+		assert.Error(t, err)
+
+		_, err = putObject(first, layer, TestBucket, TestFile2, nil, map[string]string{"X-Amz-Meta-Alias-Target": TestFile})
+		require.NoError(t, err)
+		info, err := layer.GetObjectInfo(first, TestBucket, TestFile2, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("first tenant")), info.Size)
+	})
+
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		ObjectAliases:    true,
+		DenyReservedKeys: true,
+		ReservedPrefixes: []string{"private/"},
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		// Reserved keys can't be targeted
+		_, err = putObject(ctx, layer, TestBucket, TestFile2, nil, map[string]string{"X-Amz-Meta-Alias-Target": "private/secret"})
+		assert.Equal(t, minio.PrefixAccessDenied{Bucket: TestBucket, Object: "private/secret"}, err)
+	})
+}
+
+func TestBucketCacheInvalidation(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{BucketCacheTTL: time.Hour}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		// The missing bucket is cached
+		_, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+
+		// Creating the bucket invalidates the cache
+		require.NoError(t, layer.MakeBucketWithLocation(ctx, TestBucket, ""))
+
+		_, err = putObject(ctx, layer, TestBucket, TestFile, []byte("test"), nil)
+		require.NoError(t, err)
+
+		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		// Deleting the bucket invalidates the cache
+		require.NoError(t, layer.DeleteObject(ctx, TestBucket, TestFile))
+		require.NoError(t, layer.DeleteBucket(ctx, TestBucket, false))
+
+		_, err = putObject(ctx, layer, TestBucket, TestFile, []byte("test"), nil)
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+	})
+}
+
+func TestPutObjectBucketMetadata(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		BucketMetadata: map[string]map[string]string{
+			TestBucket: {"X-Amz-Meta-Project": "album", "Cache-Control": "max-age=3600"},
+		},
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		for _, bucket := range []string{TestBucket, DestBucket} {
+			_, err := m.CreateBucket(ctx, bucket, nil)
+			require.NoError(t, err)
+		}
+
+		// The defaults are added to the metadata the client doesn't set
+		_, err := putObject(ctx, layer, TestBucket, TestFile, []byte("test"), map[string]string{"content-type": "text/plain"})
+		require.NoError(t, err)
+
+		info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "album", info.UserDefined["X-Amz-Meta-Project"])
+		assert.Equal(t, "max-age=3600", info.UserDefined["Cache-Control"])
+		assert.Equal(t, "text/plain", info.UserDefined["content-type"])
+
+		// The metadata set by the client wins
+		_, err = putObject(ctx, layer, TestBucket, TestFile2, []byte("test"), map[string]string{"x-amz-meta-project": "other"})
+		require.NoError(t, err)
+
+		info, err = layer.GetObjectInfo(ctx, TestBucket, TestFile2, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "other", info.UserDefined["x-amz-meta-project"])
+		assert.NotContains(t, info.UserDefined, "X-Amz-Meta-Project")
+		assert.Equal(t, "max-age=3600", info.UserDefined["Cache-Control"])
+
+		// Other buckets have no defaults
+		_, err = putObject(ctx, layer, DestBucket, TestFile, []byte("test"), nil)
+		require.NoError(t, err)
+
+		info, err = layer.GetObjectInfo(ctx, DestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.NotContains(t, info.UserDefined, "X-Amz-Meta-Project")
+		assert.NotContains(t, info.UserDefined, "Cache-Control")
+	})
+}
+
+func TestGetObjectReadahead(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		Readahead: miniogw.ReadaheadConfig{Enabled: true, Size: 100 * memory.KiB},
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(1 * memory.MiB.Int())
+		_, err = putObject(ctx, layer, TestBucket, TestFile, data, nil)
+		require.NoError(t, err)
+
+		reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, data, content)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 1000, 5000, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, data[1000:6000], buf.Bytes())
+
+		// Closing the reader before the content is read stops prefetching
+		reader, err = layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+	})
+}
+
+func TestReadaheadReaderCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	reader := miniogw.NewReadaheadReader(ctx, &latencyReader{reader: bytes.NewReader(testrand.BytesInt(1000)), latency: 100 * time.Millisecond}, memory.KiB)
+	cancel()
+
+	_, err := reader.Read(make([]byte, 10))
+	assert.Equal(t, context.Canceled, err)
+	require.NoError(t, reader.Close())
+}
+
+func TestReadaheadReaderClose(t *testing.T) {
+	source := &closedReader{reader: &latencyReader{reader: bytes.NewReader(testrand.BytesInt(1000)), latency: 10 * time.Millisecond}}
+	reader := miniogw.NewReadaheadReader(context.Background(), source, 10)
+
+	_, err := reader.Read(make([]byte, 10))
+	require.NoError(t, err)
+
+	// The source isn't read anymore once the reader is closed, so it can be
+	// closed too
+	require.NoError(t, reader.Close())
+	atomic.StoreInt32(&source.closed, 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, atomic.LoadInt32(&source.readAfterClose))
+}
+
+// closedReader counts the reads after it's marked as closed.
+type closedReader struct {
+	reader         io.Reader
+	closed         int32
+	readAfterClose int32
+}
+
+func (reader *closedReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	if atomic.LoadInt32(&reader.closed) != 0 {
+		atomic.AddInt32(&reader.readAfterClose, 1)
+	}
+	return n, err
+}
+
+// latencyReader simulates a high latency link by waiting before each read of
+// at most 32 KiB.
+type latencyReader struct {
+	reader  io.Reader
+	latency time.Duration
+}
+
+func (reader *latencyReader) Read(p []byte) (int, error) {
+	time.Sleep(reader.latency)
+	if len(p) > 32*memory.KiB.Int() {
+		p = p[:32*memory.KiB.Int()]
+	}
+	return reader.reader.Read(p)
+}
+
+func BenchmarkReadahead(b *testing.B) {
+	const chunk = 32 * memory.KiB
+	const latency = time.Millisecond
+
+	data := testrand.BytesInt(4 * memory.MiB.Int())
+
+	// The stored content arrives in chunks after the latency of the link and
+	// the client takes as long to consume each chunk.
+	download := func(readahead bool) {
+		var reader io.Reader = &latencyReader{reader: bytes.NewReader(data), latency: latency}
+		if readahead {
+			readaheadReader := miniogw.NewReadaheadReader(context.Background(), reader, 4*memory.MiB)
+			defer func() { _ = readaheadReader.Close() }()
+			reader = readaheadReader
+		}
+
+		buf := make([]byte, chunk)
+		for {
+			_, err := io.ReadFull(reader, buf)
+			if err != nil {
+				break
+			}
+			time.Sleep(latency)
+		}
+	}
+
+	b.Run("Disabled", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			download(false)
+		}
+	})
+
+	b.Run("Enabled", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			download(true)
+		}
+	})
+}
+
+func TestDeleteObjectsEmpty(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		errors, err := layer.DeleteObjects(ctx, TestBucket, []string{})
 		assert.NoError(t, err)
+		assert.Empty(t, errors)
+	})
 
-		// Check the error when deleting an object with empty name
-		errors, err = layer.DeleteObjects(ctx, TestBucket, []string{""})
-		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, err)
-		assert.Equal(t, minio.ObjectNameInvalid{Bucket: TestBucket}, errors[0])
+	// the key mapping layer of reserved keys answers deletes without objects
+	// itself
+	config := miniogw.Config{StrictDeleteObjects: true, DenyReservedKeys: true}
+	runTestWithConfig(t, storj.EncNull, config, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
 
-		// Check the error when deleting a non-existing object
-		errors, err = layer.DeleteObjects(ctx, TestBucket, []string{TestFile})
-		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
-		assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, errors[0])
+		deleteRequest := func(body string) context.Context {
+			return miniogw.WithRequest(ctx, httptest.NewRequest(http.MethodPost, "/"+TestBucket+"?delete=", strings.NewReader(body)))
+		}
 
-		// Create the 3 objects using the Metainfo API
-		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile, nil, nil)
-		assert.NoError(t, err)
-		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile2, nil, nil)
-		assert.NoError(t, err)
-		_, err = createFile(ctx, m, strms, testBucketInfo, TestFile3, nil, nil)
-		assert.NoError(t, err)
+		_, err = layer.DeleteObjects(deleteRequest(`<Delete><Quiet>false</Quiet></Delete>`), TestBucket, []string{})
+		assert.Equal(t, objectlock.ErrMalformedXML, err)
 
-		// Delete the 1st and the 3rd object using the Minio API
-		errors, err = layer.DeleteObjects(ctx, TestBucket, []string{TestFile, TestFile3})
+		// minio drops the objects it denies before calling the layer, which
+		// isn't malformed
+		errors, err := layer.DeleteObjects(deleteRequest(`<Delete><Object><Key>`+TestFile+`</Key></Object></Delete>`), TestBucket, []string{})
 		assert.NoError(t, err)
-		require.Len(t, errors, 2)
-		assert.NoError(t, errors[0])
-		assert.NoError(t, errors[1])
+		assert.Empty(t, errors)
 
-		// Check using the Metainfo API that the 1st and the 3rd objects are deleted, but the 2nd is still there
-		_, err = m.GetObject(ctx, testBucketInfo, TestFile)
-		assert.True(t, storj.ErrObjectNotFound.Has(err))
-		_, err = m.GetObject(ctx, testBucketInfo, TestFile2)
-		assert.NoError(t, err)
-		_, err = m.GetObject(ctx, testBucketInfo, TestFile3)
-		assert.True(t, storj.ErrObjectNotFound.Has(err))
+		// the body is put back for minio to read it
+		r := httptest.NewRequest(http.MethodPost, "/"+TestBucket+"?delete=", strings.NewReader(`<Delete/>`))
+		miniogw.WithRequest(ctx, r)
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, `<Delete/>`, string(body))
 	})
 }
 
@@ -798,57 +2953,562 @@ func testListObjects(t *testing.T, listObjects func(*testing.T, context.Context,
 	})
 }
 
+func TestListObjectsDelimiterKeys(t *testing.T) {
+	runTestWithPathCipher(t, storj.EncNull, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		filePaths := []string{"/", "//", "///", "a//b"}
+		for _, filePath := range filePaths {
+			_, err := createFile(ctx, m, strms, testBucketInfo, filePath, nil, []byte("test"))
+			require.NoError(t, err)
+		}
+
+		for i, tt := range []struct {
+			prefix    string
+			delimiter string
+			prefixes  []string
+			objects   []string
+		}{
+			{
+				objects: []string{"/", "//", "///", "a//b"},
+			}, {
+				delimiter: "/",
+				prefixes:  []string{"/", "a/"},
+			}, {
+				prefix:    "/",
+				delimiter: "/",
+				prefixes:  []string{"//"},
+				objects:   []string{"/"},
+			}, {
+				prefix:    "//",
+				delimiter: "/",
+				prefixes:  []string{"///"},
+				objects:   []string{"//"},
+			}, {
+				prefix:    "///",
+				delimiter: "/",
+				objects:   []string{"///"},
+			}, {
+				prefix:    "a/",
+				delimiter: "/",
+				prefixes:  []string{"a//"},
+			},
+		} {
+			errTag := fmt.Sprintf("%d. %+v", i, tt)
+
+			// List one item per page to verify that paging always advances
+			var prefixes, objects []string
+			marker := ""
+			for page := 0; ; page++ {
+				require.True(t, page <= len(filePaths), errTag)
+
+				list, err := layer.ListObjects(ctx, TestBucket, tt.prefix, marker, tt.delimiter, 1)
+				require.NoError(t, err, errTag)
+
+				prefixes = append(prefixes, list.Prefixes...)
+				for _, object := range list.Objects {
+					objects = append(objects, object.Name)
+				}
+
+				if !list.IsTruncated {
+					break
+				}
+				marker = list.NextMarker
+			}
+
+			assert.Equal(t, tt.prefixes, prefixes, errTag)
+			assert.Equal(t, tt.objects, objects, errTag)
+		}
+	})
+}
+
+func TestListObjectsDeletedMarker(t *testing.T) {
+	runTestWithPathCipher(t, storj.EncNull, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		for _, filePath := range []string{"a", "b", "c", "dir/d", "dir/e"} {
+			_, err := createFile(ctx, m, strms, testBucketInfo, filePath, nil, []byte("test"))
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, layer.DeleteObject(ctx, TestBucket, "b"))
+		require.NoError(t, layer.DeleteObject(ctx, TestBucket, "dir/d"))
+
+		names := func(objects []minio.ObjectInfo) (names []string) {
+			for _, object := range objects {
+				names = append(names, object.Name)
+			}
+			return names
+		}
+
+		// The marker is a position, it doesn't need to exist
+		list, err := layer.ListObjects(ctx, TestBucket, "", "b", "", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "dir/e"}, names(list.Objects))
+
+		list, err = layer.ListObjects(ctx, TestBucket, "dir/", "dir/d", "/", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"dir/e"}, names(list.Objects))
+
+		listV2, err := layer.ListObjectsV2(ctx, TestBucket, "", "b", "/", 0, false, "")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c"}, names(listV2.Objects))
+		assert.Equal(t, []string{"dir/"}, listV2.Prefixes)
+
+		listV2, err = layer.ListObjectsV2(ctx, TestBucket, "", "", "", 0, false, "b")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "dir/e"}, names(listV2.Objects))
+	})
+}
+
+func TestListObjectsDeletedBucket(t *testing.T) {
+	runTestWithPathCipher(t, storj.EncNull, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		filePaths := []string{"a", "b", "c"}
+		for _, filePath := range filePaths {
+			_, err := createFile(ctx, m, strms, testBucketInfo, filePath, nil, []byte("test"))
+			require.NoError(t, err)
+		}
+
+		list, err := layer.ListObjects(ctx, TestBucket, "", "", "", 1)
+		require.NoError(t, err)
+		require.True(t, list.IsTruncated)
+
+		listV2, err := layer.ListObjectsV2(ctx, TestBucket, "", "", "", 1, false, "")
+		require.NoError(t, err)
+		require.True(t, listV2.IsTruncated)
+
+		// Delete the bucket between the pages
+		for _, filePath := range filePaths {
+			require.NoError(t, layer.DeleteObject(ctx, TestBucket, filePath))
+		}
+		require.NoError(t, layer.DeleteBucket(ctx, TestBucket, false))
+
+		_, err = layer.ListObjects(ctx, TestBucket, "", list.NextMarker, "", 1)
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+
+		_, err = layer.ListObjectsV2(ctx, TestBucket, "", listV2.NextContinuationToken, "", 1, false, "")
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+	})
+}
+
+func TestListObjectsConcurrentWrites(t *testing.T) {
+	runTestWithPathCipher(t, storj.EncNull, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		var stable []string
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key-%02d", 2*i)
+			_, err := putObject(ctx, layer, TestBucket, key, []byte("test"), nil)
+			require.NoError(t, err)
+			stable = append(stable, key)
+		}
+
+		// Add and remove keys between the stable ones during the listing
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				key := fmt.Sprintf("key-%02d", 2*(i%10)+1)
+				if _, err := putObject(ctx, layer, TestBucket, key, []byte("test"), nil); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%2 == 1 {
+					if err := layer.DeleteObject(ctx, TestBucket, key); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+		defer func() {
+			close(done)
+			wg.Wait()
+		}()
+
+		check := func(listed []string) {
+			seen := make(map[string]bool)
+			for _, key := range listed {
+				assert.False(t, seen[key], "listed twice: "+key)
+				seen[key] = true
+			}
+			for _, key := range stable {
+				assert.True(t, seen[key], "not listed: "+key)
+			}
+		}
+
+		var listed []string
+		marker := ""
+		for {
+			list, err := layer.ListObjects(ctx, TestBucket, "", marker, "", 3)
+			require.NoError(t, err)
+			for _, object := range list.Objects {
+				listed = append(listed, object.Name)
+			}
+			if !list.IsTruncated {
+				break
+			}
+			marker = list.NextMarker
+		}
+		check(listed)
+
+		listed = nil
+		token := ""
+		for {
+			list, err := layer.ListObjectsV2(ctx, TestBucket, "", token, "", 3, false, "")
+			require.NoError(t, err)
+			for _, object := range list.Objects {
+				listed = append(listed, object.Name)
+			}
+			if !list.IsTruncated {
+				break
+			}
+			token = list.NextContinuationToken
+		}
+		check(listed)
+	})
+}
+
+func TestListObjectsKeyEqualToPrefix(t *testing.T) {
+	runTestWithPathCipher(t, storj.EncNull, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		for _, filePath := range []string{"a/", "a/b", "a/c/d"} {
+			_, err := createFile(ctx, m, strms, testBucketInfo, filePath, nil, []byte("test"))
+			require.NoError(t, err)
+		}
+
+		list, err := layer.ListObjects(ctx, TestBucket, "a/", "", "/", 0)
+		require.NoError(t, err)
+		assert.False(t, list.IsTruncated)
+		assert.Equal(t, []string{"a/c/"}, list.Prefixes)
+
+		var objects []string
+		for _, object := range list.Objects {
+			objects = append(objects, object.Name)
+		}
+		assert.Equal(t, []string{"a/", "a/b"}, objects)
+
+		listV2, err := layer.ListObjectsV2(ctx, TestBucket, "a/", "", "/", 0, false, "")
+		require.NoError(t, err)
+		assert.Equal(t, list.Prefixes, listV2.Prefixes)
+		assert.Equal(t, list.Objects, listV2.Objects)
+	})
+}
+
+func TestListObjectsExactlyMaxKeys(t *testing.T) {
+	// the index entries sort after the objects, but aren't listed
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		Index: miniogw.IndexConfig{Keys: "color", Prefix: "zz-index/"},
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		keys := []string{"a", "b", "c"}
+		for _, key := range keys {
+			_, err = putObject(ctx, layer, TestBucket, key, []byte("test"), map[string]string{"color": "red"})
+			require.NoError(t, err)
+		}
+
+		list, err := layer.ListObjects(ctx, TestBucket, "", "", "", len(keys))
+		require.NoError(t, err)
+		assert.Len(t, list.Objects, len(keys))
+		assert.False(t, list.IsTruncated)
+		assert.Empty(t, list.NextMarker)
+
+		listV2, err := layer.ListObjectsV2(ctx, TestBucket, "", "", "", len(keys), false, "")
+		require.NoError(t, err)
+		assert.Len(t, listV2.Objects, len(keys))
+		assert.False(t, listV2.IsTruncated)
+		assert.Empty(t, listV2.NextContinuationToken)
+
+		list, err = layer.ListObjects(ctx, TestBucket, "", "", "", len(keys)-1)
+		require.NoError(t, err)
+		assert.True(t, list.IsTruncated)
+		assert.Equal(t, "b", list.NextMarker)
+	})
+}
+
+func TestReopenProject(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		for i, config := range []miniogw.Config{
+			{ReopenWait: 0},
+			{ReopenWait: time.Minute},
+		} {
+			gateway, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, config)
+			require.NoError(t, err)
+
+			bucket := TestBucket + strconv.Itoa(i)
+			_, err = m.CreateBucket(ctx, bucket, nil)
+			require.NoError(t, err)
+
+			// Issue requests concurrently with the reopen
+			var wg sync.WaitGroup
+			var rejected int64
+			errors := make(chan error, 20)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errors <- gateway.ReopenProjects(ctx)
+			}()
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := layer.GetBucketInfo(ctx, bucket)
+					if err == (minio.InsufficientReadQuorum{}) {
+						// the request is rejected with a retryable error
+						atomic.AddInt64(&rejected, 1)
+						return
+					}
+					errors <- err
+				}()
+			}
+			wg.Wait()
+			close(errors)
+
+			for err := range errors {
+				assert.NoError(t, err)
+			}
+			if config.ReopenWait > 0 {
+				assert.Zero(t, atomic.LoadInt64(&rejected))
+			}
+
+			// Requests succeed after the reopen is complete
+			_, err = layer.GetBucketInfo(ctx, bucket)
+			assert.NoError(t, err)
+
+			// Downloads started before a reopen aren't interrupted by it
+			data := testrand.BytesInt(100 * memory.KiB.Int())
+			_, err = putObject(ctx, layer, bucket, TestFile, data, nil)
+			require.NoError(t, err)
+
+			reader, err := layer.GetObjectNInfo(ctx, bucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
+			require.NoError(t, err)
+			require.NoError(t, gateway.ReopenProjects(ctx))
+
+			downloaded, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			assert.Equal(t, data, downloaded)
+		}
+	})
+}
+
+func TestSlowStartAfterReopen(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		const duration = 3 * time.Second
+
+		gateway, layer, m, _, err := initEnv(ctx, t, planet, storj.EncNull, miniogw.Config{
+			SlowStart: miniogw.SlowStartConfig{Duration: duration, Initial: 1, Limit: 3},
+		})
+		require.NoError(t, err)
+
+		_, err = m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+		_, err = putObject(ctx, layer, TestBucket, TestFile, []byte("test"), nil)
+		require.NoError(t, err)
+
+		require.NoError(t, gateway.ReopenProjects(ctx))
+		reopened := time.Now()
+
+		stat := func(timeout time.Duration) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			_, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+			return err
+		}
+
+		// Right after the reopen only one request is served at a time, the
+		// download holds it until it's closed
+		reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		if time.Since(reopened) < duration/6 {
+			assert.Equal(t, context.DeadlineExceeded, stat(duration/6))
+		}
+
+		// Later in the ramp, another request is served concurrently
+		time.Sleep(time.Until(reopened.Add(duration / 2)))
+		assert.NoError(t, stat(duration))
+
+		// After the ramp the requests are unlimited
+		time.Sleep(time.Until(reopened.Add(duration)))
+		var wg sync.WaitGroup
+		errors := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errors <- stat(duration / 4)
+			}()
+		}
+		wg.Wait()
+		close(errors)
+		for err := range errors {
+			assert.NoError(t, err)
+		}
+
+		require.NoError(t, reader.Close())
+	})
+}
+
+func TestGetObjectExpiration(t *testing.T) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		apiKey := planet.Uplinks[0].APIKey[planet.Satellites[0].ID()]
+		access, err := uplink.RequestAccessWithPassphrase(ctx, planet.Satellites[0].URL(), apiKey.Serialize(), "passphrase")
+		require.NoError(t, err)
+
+		project, err := uplink.OpenProject(ctx, access)
+		require.NoError(t, err)
+		defer ctx.Check(project.Close)
+
+		_, err = project.CreateBucket(ctx, TestBucket)
+		require.NoError(t, err)
+
+		expires := time.Date(2030, time.March, 4, 5, 6, 7, 0, time.UTC)
+		upload, err := project.UploadObject(ctx, TestBucket, TestFile, &uplink.UploadOptions{Expires: expires})
+		require.NoError(t, err)
+		_, err = upload.Write([]byte("test"))
+		require.NoError(t, err)
+		require.NoError(t, upload.Commit())
+
+		upload, err = project.UploadObject(ctx, TestBucket, TestFile2, nil)
+		require.NoError(t, err)
+		_, err = upload.Write([]byte("test"))
+		require.NoError(t, err)
+		require.NoError(t, upload.Commit())
+
+		layer, err := miniogw.NewStorjGateway(access, uplink.Config{}, miniogw.Config{
+			ExpirationRuleID: "expiry",
+		}).NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+		// HEAD and GET report when and by which rule the object expires
+		expected := `expiry-date="Mon, 04 Mar 2030 05:06:07 GMT", rule-id="expiry"`
+
+		info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, expected, info.UserDefined["X-Amz-Expiration"])
+
+		reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, nil, nil, 0, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, expected, reader.ObjInfo.UserDefined["X-Amz-Expiration"])
+		require.NoError(t, reader.Close())
+
+		// Objects without an expiry don't have the header
+		info, err = layer.GetObjectInfo(ctx, TestBucket, TestFile2, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.NotContains(t, info.UserDefined, "X-Amz-Expiration")
+
+		// The header is reported, not stored with copies
+		srcInfo, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		_, err = layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, TestFile3, srcInfo, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		copied, err := project.StatObject(ctx, TestBucket, TestFile3)
+		require.NoError(t, err)
+		assert.NotContains(t, copied.Custom, "X-Amz-Expiration")
+
+		// The header is disabled without a rule id
+		disabled, err := miniogw.NewStorjGateway(access, uplink.Config{}, miniogw.Config{}).NewGatewayLayer(auth.Credentials{})
+		require.NoError(t, err)
+		defer ctx.Check(func() error { return disabled.Shutdown(ctx) })
+
+		info, err = disabled.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.NotContains(t, info.UserDefined, "X-Amz-Expiration")
+	})
+}
+
 func runTest(t *testing.T, test func(*testing.T, context.Context, minio.ObjectLayer, *kvmetainfo.DB, streams.Store)) {
 	runTestWithPathCipher(t, storj.EncNull, test)
 }
 
 func runTestWithPathCipher(t *testing.T, pathCipher storj.CipherSuite, test func(*testing.T, context.Context, minio.ObjectLayer, *kvmetainfo.DB, streams.Store)) {
+	runTestWithConfig(t, pathCipher, miniogw.Config{}, test)
+}
+
+// signedContext returns ctx of a request signed with the access key.
+func signedContext(ctx context.Context, accessKey string) context.Context {
+	request := httptest.NewRequest(http.MethodGet, "/"+TestBucket+"/", nil)
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/20200101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=...")
+	return miniogw.WithRequest(ctx, request)
+}
+
+func runTestWithConfig(t *testing.T, pathCipher storj.CipherSuite, config miniogw.Config, test func(*testing.T, context.Context, minio.ObjectLayer, *kvmetainfo.DB, streams.Store)) {
 	testplanet.Run(t, testplanet.Config{
 		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
 		NonParallel: true,
 	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
-		layer, m, strms, err := initEnv(ctx, t, planet, pathCipher)
+		_, layer, m, strms, err := initEnv(ctx, t, planet, pathCipher, config)
 		require.NoError(t, err)
 
 		test(t, ctx, layer, m, strms)
 	})
 }
 
-func initEnv(ctx context.Context, t *testing.T, planet *testplanet.Planet, pathCipher storj.CipherSuite) (minio.ObjectLayer, *kvmetainfo.DB, streams.Store, error) {
+func initEnv(ctx context.Context, t *testing.T, planet *testplanet.Planet, pathCipher storj.CipherSuite, config miniogw.Config) (*miniogw.Gateway, minio.ObjectLayer, *kvmetainfo.DB, streams.Store, error) {
 	apiKey := planet.Uplinks[0].APIKey[planet.Satellites[0].ID()]
 
 	m, err := planet.Uplinks[0].DialMetainfo(ctx, planet.Satellites[0], apiKey)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 	// TODO(leak): close m metainfo.Client somehow
 
 	access, err := uplink.RequestAccessWithPassphrase(ctx, planet.Satellites[0].URL(), apiKey.Serialize(), "passphrase")
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	serializedAccess, err := access.Serialize()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	oldAccess, err := olduplink.ParseScope(serializedAccess)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 	oldAccess.EncryptionAccess.SetDefaultPathCipher(pathCipher)
 	encStore := oldAccess.EncryptionAccess.Store()
 
 	serializedOldAccess, err := oldAccess.Serialize()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	// workaround to set proper path cipher for uplink.Access
 	access, err = uplink.ParseAccess(serializedOldAccess)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	ec := ecclient.NewClient(planet.Uplinks[0].Log.Named("ecclient"), planet.Uplinks[0].Dialer, 0)
@@ -859,21 +3519,32 @@ func initEnv(ctx context.Context, t *testing.T, planet *testplanet.Planet, pathC
 	inlineThreshold := 4 * memory.KiB.Int()
 	strms, err := streams.NewStreamStore(m, segments, 64*memory.MiB.Int64(), encStore, blockSize, storj.EncAESGCM, inlineThreshold, 8*memory.MiB.Int64())
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	p, err := kvmetainfo.SetupProject(m)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 	kvm := kvmetainfo.New(p, m, strms, segments, encStore)
 
-	gateway := miniogw.NewStorjGateway(access, uplink.Config{}, false)
-	layer, err := gateway.NewGatewayLayer(auth.Credentials{})
+	gateway := miniogw.NewStorjGateway(access, uplink.Config{}, config)
+	layer, err := gateway.NewGatewayLayer(auth.Credentials{AccessKey: TestAccessKey})
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return gateway, layer, kvm, strms, err
+}
+
+func putObject(ctx context.Context, layer minio.ObjectLayer, bucket, object string, data []byte, metadata map[string]string) (minio.ObjectInfo, error) {
+	hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)), true)
 	if err != nil {
-		return nil, nil, nil, err
+		return minio.ObjectInfo{}, err
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
 	}
-	return layer, kvm, strms, err
+	return layer.PutObject(ctx, bucket, object, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{UserDefined: metadata})
 }
 
 func createFile(ctx context.Context, m *kvmetainfo.DB, strms streams.Store, bucket storj.Bucket, path storj.Path, createInfo *kvmetainfo.CreateObject, data []byte) (storj.Object, error) {
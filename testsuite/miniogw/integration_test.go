@@ -4,19 +4,29 @@
 package miniogw_test
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/url"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/btcsuite/btcutil/base58"
+	miniov6 "github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/signer"
 	"github.com/stretchr/testify/require"
 	"github.com/zeebo/errs"
 	"go.uber.org/zap"
@@ -181,6 +191,668 @@ func TestUploadDownload(t *testing.T) {
 	})
 }
 
+func TestExpectContinueEarlyReject(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		data := &trackingReader{reader: bytes.NewReader(testrand.BytesInt(5000))}
+
+		request, err := gateway.newRequest(http.MethodPut, "/missing-bucket/testdata", data, 5000)
+		require.NoError(t, err)
+		request.Header.Set("Expect", "100-continue")
+
+		client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+		response, err := client.Do(request)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, response.Body.Close()) }()
+
+		body, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		require.Contains(t, string(body), "NoSuchBucket")
+		require.Zero(t, atomic.LoadInt64(&data.read), "the body must not be sent")
+	})
+}
+
+// TestZeroContentLengthWithBody covers uploads that send bytes after a
+// "Content-Length: 0" header. The HTTP server frames the body according to
+// Content-Length before the gateway layer is called, so the bytes aren't part
+// of the upload and there is no option in the gateway to change that. An
+// empty object is stored, unless the client signed the payload hash, which
+// then doesn't match and the upload is rejected.
+func TestZeroContentLengthWithBody(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+
+		body := []byte("unexpected body")
+
+		// An unsigned payload is framed by Content-Length only, so the body
+		// is not part of the request and an empty object is stored.
+		request, err := gateway.newRequest(http.MethodPut, "/bucket/unsigned", nil, 0)
+		require.NoError(t, err)
+
+		response, err := sendWithStrayBody(gateway.Address, request, body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		info, err := client.API.StatObject("bucket", "unsigned", miniov6.StatObjectOptions{})
+		require.NoError(t, err)
+		require.Zero(t, info.Size)
+
+		// A signed payload declares the hash of the body, which doesn't
+		// match the empty content, so the request is rejected.
+		sum := sha256.Sum256(body)
+		request, err = http.NewRequest(http.MethodPut, "http://"+gateway.Address+"/bucket/signed", nil)
+		require.NoError(t, err)
+		request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		request = signer.SignV4(*request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1")
+
+		response, err = sendWithStrayBody(gateway.Address, request, body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		_, err = client.API.StatObject("bucket", "signed", miniov6.StatObjectOptions{})
+		require.Error(t, err)
+	})
+}
+
+func TestCopyObjectEncodedSource(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+
+		data := testrand.BytesInt(100)
+
+		for i, tt := range []struct {
+			key    string
+			source string
+		}{
+			{key: "file with spaces.txt", source: "/bucket/file%20with%20spaces.txt"},
+			{key: "dir/sub/file", source: "bucket/dir%2Fsub%2Ffile"},
+			{key: "dir/sub dir/file+plus", source: "/bucket/dir/sub%20dir%2Ffile%2Bplus"},
+			{key: "dir/unencoded", source: "/bucket/dir/unencoded"},
+		} {
+			errTag := fmt.Sprintf("%d. %+v", i, tt)
+
+			_, err = client.API.PutObject("bucket", tt.key, bytes.NewReader(data), int64(len(data)), miniov6.PutObjectOptions{})
+			require.NoError(t, err, errTag)
+
+			request, err := http.NewRequest(http.MethodPut, "http://"+gateway.Address+"/bucket/copy", nil)
+			require.NoError(t, err, errTag)
+			request.Header.Set("X-Amz-Copy-Source", tt.source)
+			request.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+			request = signer.SignV4(*request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1")
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err, errTag)
+			body, err := ioutil.ReadAll(response.Body)
+			require.NoError(t, err, errTag)
+			require.NoError(t, response.Body.Close(), errTag)
+			require.Equal(t, http.StatusOK, response.StatusCode, string(body))
+
+			copied, err := client.Download("bucket", "copy", make([]byte, len(data)))
+			require.NoError(t, err, errTag)
+			require.Equal(t, data, copied, errTag)
+
+			require.NoError(t, client.API.RemoveObject("bucket", "copy"), errTag)
+		}
+	})
+}
+
+func TestCopyObjectToSelfKeepsETag(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+		require.NoError(t, client.Upload("bucket", "object", testrand.BytesInt(100)))
+
+		before, err := client.API.StatObject("bucket", "object", miniov6.StatObjectOptions{})
+		require.NoError(t, err)
+
+		// User metadata on the destination makes it a REPLACE copy
+		source := miniov6.NewSourceInfo("bucket", "object", nil)
+		destination, err := miniov6.NewDestinationInfo("bucket", "object", nil, map[string]string{"key": "value"})
+		require.NoError(t, err)
+		require.NoError(t, client.API.CopyObject(destination, source))
+
+		after, err := client.API.StatObject("bucket", "object", miniov6.StatObjectOptions{})
+		require.NoError(t, err)
+		require.Equal(t, before.ETag, after.ETag)
+		require.Equal(t, before.Size, after.Size)
+	})
+}
+
+func TestDeleteObjectsEmptyStrict(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+		_, err = client.API.PutObject("bucket", "object", bytes.NewReader([]byte("test")), 4, miniov6.PutObjectOptions{})
+		require.NoError(t, err)
+
+		deleteObjects := func(body string) (int, string) {
+			sum := md5.Sum([]byte(body))
+
+			request, err := http.NewRequest(http.MethodPost, "http://"+gateway.Address+"/bucket?delete=", strings.NewReader(body))
+			require.NoError(t, err)
+			request.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+			request.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+			request = signer.SignV4(*request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1")
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, response.Body.Close()) }()
+
+			responseBody, err := ioutil.ReadAll(response.Body)
+			require.NoError(t, err)
+			return response.StatusCode, string(responseBody)
+		}
+
+		// the reserved keys map the keys of the requests, which answers
+		// deletes without objects without reaching the gateway layer
+		status, body := deleteObjects(`<Delete xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Quiet>false</Quiet></Delete>`)
+		require.Equal(t, http.StatusBadRequest, status)
+		require.Contains(t, body, "MalformedXML")
+
+		status, body = deleteObjects(`<Delete xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Object><Key>object</Key></Object></Delete>`)
+		require.Equal(t, http.StatusOK, status)
+		require.Contains(t, body, "<Deleted><Key>object</Key>")
+
+		_, err = client.API.StatObject("bucket", "object", miniov6.StatObjectOptions{})
+		require.Error(t, err)
+	}, "--strict-delete-objects", "--deny-reserved-keys")
+}
+
+func TestPutObjectPayloadSigning(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+
+		data := testrand.BytesInt(5000)
+
+		send := func(request *http.Request) (int, string) {
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(response.Body)
+			require.NoError(t, err)
+			require.NoError(t, response.Body.Close())
+			return response.StatusCode, string(body)
+		}
+
+		// UNSIGNED-PAYLOAD skips hashing the body
+		request, err := gateway.newRequest(http.MethodPut, "/bucket/unsigned", bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		status, body := send(request)
+		require.Equal(t, http.StatusOK, status, body)
+
+		// but the rest of the request is still verified
+		request, err = http.NewRequest(http.MethodPut, "http://"+gateway.Address+"/bucket/forged", bytes.NewReader(data))
+		require.NoError(t, err)
+		request.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		request = signer.SignV4(*request, gateway.AccessKey, "wrong-secret-key", "", "us-east-1")
+		status, body = send(request)
+		require.Equal(t, http.StatusForbidden, status)
+		require.Contains(t, body, "SignatureDoesNotMatch")
+
+		// STREAMING-AWS4-HMAC-SHA256-PAYLOAD signs the body chunk by chunk
+		request, err = http.NewRequest(http.MethodPut, "http://"+gateway.Address+"/bucket/streaming", bytes.NewReader(data))
+		require.NoError(t, err)
+		request = signer.StreamingSignV4(request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1", int64(len(data)), time.Now().UTC())
+		status, body = send(request)
+		require.Equal(t, http.StatusOK, status, body)
+
+		for _, key := range []string{"unsigned", "streaming"} {
+			downloaded, err := client.Download("bucket", key, make([]byte, len(data)))
+			require.NoError(t, err, key)
+			require.Equal(t, data, downloaded, key)
+		}
+
+		_, err = client.API.StatObject("bucket", "forged", miniov6.StatObjectOptions{})
+		require.Error(t, err)
+	})
+}
+
+func TestPutObjectChunkedTransferEncoding(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+
+		// larger than a single chunk of the streaming signature
+		data := testrand.BytesInt(200 * memory.KiB.Int())
+
+		// A streaming signature declares the decoded size, so the body can be
+		// sent without Content-Length.
+		request, err := http.NewRequest(http.MethodPut, "http://"+gateway.Address+"/bucket/streaming", bytes.NewReader(data))
+		require.NoError(t, err)
+		request = signer.StreamingSignV4(request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1", int64(len(data)), time.Now().UTC())
+		request.ContentLength = -1
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, http.StatusOK, response.StatusCode, string(body))
+
+		info, err := client.API.StatObject("bucket", "streaming", miniov6.StatObjectOptions{})
+		require.NoError(t, err)
+		require.EqualValues(t, len(data), info.Size)
+
+		downloaded, err := client.Download("bucket", "streaming", make([]byte, len(data)))
+		require.NoError(t, err)
+		require.Equal(t, data, downloaded)
+
+		// Without a declared size the upload is rejected instead of storing
+		// a truncated object.
+		request, err = gateway.newRequest(http.MethodPut, "/bucket/unsized", bytes.NewReader(data), -1)
+		require.NoError(t, err)
+
+		response, err = http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		body, err = ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, http.StatusLengthRequired, response.StatusCode)
+		require.Contains(t, string(body), "MissingContentLength")
+
+		_, err = client.API.StatObject("bucket", "unsized", miniov6.StatObjectOptions{})
+		require.Error(t, err)
+	})
+}
+
+func TestPostPolicyUpload(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+
+		policy := miniov6.NewPostPolicy()
+		require.NoError(t, policy.SetBucket("bucket"))
+		require.NoError(t, policy.SetKeyStartsWith("uploads/"))
+		require.NoError(t, policy.SetContentType("text/plain"))
+		require.NoError(t, policy.SetContentLengthRange(1, 100))
+		require.NoError(t, policy.SetExpires(time.Now().UTC().Add(time.Hour)))
+
+		target, formData, err := client.API.PresignedPostPolicy(policy)
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(50)
+
+		for i, tt := range []struct {
+			key         string
+			contentType string
+			data        []byte
+			status      int
+			errorCode   string
+		}{
+			{key: "uploads/file", contentType: "text/plain", data: data, status: http.StatusNoContent},
+			{key: "other/file", contentType: "text/plain", data: data, status: http.StatusForbidden, errorCode: "AccessDenied"},
+			{key: "uploads/image", contentType: "image/png", data: data, status: http.StatusForbidden, errorCode: "AccessDenied"},
+			{key: "uploads/large", contentType: "text/plain", data: testrand.BytesInt(200), status: http.StatusBadRequest, errorCode: "EntityTooLarge"},
+		} {
+			errTag := fmt.Sprintf("%d. %s", i, tt.key)
+
+			fields := map[string]string{}
+			for name, value := range formData {
+				fields[name] = value
+			}
+			fields["key"] = tt.key
+			fields["Content-Type"] = tt.contentType
+
+			request, err := newPostRequest(target.String(), fields, tt.data)
+			require.NoError(t, err, errTag)
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err, errTag)
+			body, err := ioutil.ReadAll(response.Body)
+			require.NoError(t, err, errTag)
+			require.NoError(t, response.Body.Close(), errTag)
+			require.Equal(t, tt.status, response.StatusCode, string(body))
+
+			if tt.errorCode != "" {
+				require.Contains(t, string(body), tt.errorCode, errTag)
+
+				_, err = client.API.StatObject("bucket", tt.key, miniov6.StatObjectOptions{})
+				require.Error(t, err, errTag)
+				continue
+			}
+
+			info, err := client.API.StatObject("bucket", tt.key, miniov6.StatObjectOptions{})
+			require.NoError(t, err, errTag)
+			require.Equal(t, tt.contentType, info.ContentType, errTag)
+
+			uploaded, err := client.Download("bucket", tt.key, make([]byte, len(tt.data)))
+			require.NoError(t, err, errTag)
+			require.Equal(t, tt.data, uploaded, errTag)
+		}
+	})
+}
+
+func TestGetObjectACLWebsite(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+		require.NoError(t, client.Upload("bucket", "private", testrand.BytesInt(100)))
+
+		data := testrand.BytesInt(100)
+		request, err := gateway.newRequest(http.MethodPut, "/bucket/public", bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		request.Header.Set("X-Amz-Tagging", "public=true")
+		request = signer.SignV4(*request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1")
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		getACL := func(object string, signed bool) (int, string) {
+			request, err := http.NewRequest(http.MethodGet, "http://"+gateway.Address+"/bucket/"+object+"?acl", nil)
+			require.NoError(t, err)
+			if signed {
+				request, err = gateway.newRequest(http.MethodGet, "/bucket/"+object+"?acl", nil, 0)
+				require.NoError(t, err)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(response.Body)
+			require.NoError(t, err)
+			require.NoError(t, response.Body.Close())
+			return response.StatusCode, string(body)
+		}
+
+		const allUsers = "<URI>http://acs.amazonaws.com/groups/global/AllUsers</URI>"
+
+		// Public objects have the public-read ACL for everybody
+		for _, signed := range []bool{true, false} {
+			status, body := getACL("public", signed)
+			require.Equal(t, http.StatusOK, status, body)
+			require.Equal(t, 2, strings.Count(body, "<Grant>"), body)
+			require.Contains(t, body, "<Permission>FULL_CONTROL</Permission>")
+			require.Contains(t, body, allUsers+"</Grantee><Permission>READ</Permission>")
+		}
+
+		// Private objects have the owner-only ACL, which only the owner reads
+		status, body := getACL("private", true)
+		require.Equal(t, http.StatusOK, status, body)
+		require.Equal(t, 1, strings.Count(body, "<Grant>"), body)
+		require.Contains(t, body, "<Permission>FULL_CONTROL</Permission>")
+		require.NotContains(t, body, allUsers)
+
+		status, body = getACL("private", false)
+		require.Equal(t, http.StatusForbidden, status, body)
+	}, "--website", "--website-tag", "public=true")
+}
+
+func TestCopyObjectLimited(t *testing.T) {
+	for _, flags := range [][]string{
+		{"--concurrency.limit", "1"},
+		{"--slow-start.duration", "1h", "--slow-start.initial", "1"},
+	} {
+		runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+			client, err := gateway.newClient()
+			require.NoError(t, err)
+			require.NoError(t, client.API.MakeBucket("bucket", ""))
+			data := testrand.BytesInt(100)
+			require.NoError(t, client.Upload("bucket", "source", data))
+
+			// The copy needs no slot beyond the one of the source reader
+			request, err := gateway.newRequest(http.MethodPut, "/bucket/copy", nil, 0)
+			require.NoError(t, err)
+			request.Header.Set("X-Amz-Copy-Source", "/bucket/source")
+			request = signer.SignV4(*request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1")
+
+			response, err := (&http.Client{Timeout: 30 * time.Second}).Do(request)
+			require.NoError(t, err, flags)
+			require.NoError(t, response.Body.Close())
+			require.Equal(t, http.StatusOK, response.StatusCode, flags)
+
+			copied, err := client.Download("bucket", "copy", make([]byte, len(data)))
+			require.NoError(t, err)
+			require.Equal(t, data, copied)
+		}, flags...)
+	}
+}
+
+func TestGetObjectIfRangeChanged(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+		require.NoError(t, client.API.MakeBucket("bucket", ""))
+		require.NoError(t, client.Upload("bucket", "object", testrand.BytesInt(100)))
+
+		get := func(rangeHeader, ifRange string) (*http.Response, []byte) {
+			request, err := gateway.newRequest(http.MethodGet, "/bucket/object", nil, 0)
+			require.NoError(t, err)
+			request.Header.Set("Range", rangeHeader)
+			if ifRange != "" {
+				request.Header.Set("If-Range", ifRange)
+			}
+			request = signer.SignV4(*request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1")
+
+			response, err := (&http.Client{Timeout: 30 * time.Second}).Do(request)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(response.Body)
+			require.NoError(t, err)
+			require.NoError(t, response.Body.Close())
+			return response, body
+		}
+
+		// Start downloading the object
+		response, _ := get("bytes=0-9", "")
+		require.Equal(t, http.StatusPartialContent, response.StatusCode)
+		etag := response.Header.Get("ETag")
+
+		// Overwrite the object in the middle of the download
+		data := testrand.BytesInt(200)
+		require.NoError(t, client.Upload("bucket", "object", data))
+
+		// Resuming the download returns the whole new object
+		response, body := get("bytes=10-", etag)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Empty(t, response.Header.Get("Content-Range"))
+		require.Equal(t, strconv.Itoa(len(data)), response.Header.Get("Content-Length"))
+		require.Equal(t, data, body)
+
+		// Resuming the download of the unchanged object returns the range
+		response, body = get("bytes=10-", response.Header.Get("ETag"))
+		require.Equal(t, http.StatusPartialContent, response.StatusCode)
+		require.Equal(t, fmt.Sprintf("bytes 10-%d/%d", len(data)-1, len(data)), response.Header.Get("Content-Range"))
+		require.Equal(t, data[10:], body)
+	})
+}
+
+func TestPresignedResponseOverrides(t *testing.T) {
+	runGatewayTest(t, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway) {
+		client, err := gateway.newClient()
+		require.NoError(t, err)
+
+		bucket, objectName := "bucket", "testdata"
+		data := testrand.BytesInt(100)
+
+		require.NoError(t, client.API.MakeBucket(bucket, ""))
+		_, err = client.API.PutObject(bucket, objectName, bytes.NewReader(data), int64(len(data)), miniov6.PutObjectOptions{
+			ContentType: "application/octet-stream",
+		})
+		require.NoError(t, err)
+
+		params := url.Values{}
+		params.Set("response-content-disposition", `attachment; filename="report.csv"`)
+		params.Set("response-content-type", "text/csv")
+		params.Set("response-cache-control", "no-cache")
+
+		presigned, err := client.API.PresignedGetObject(bucket, objectName, time.Hour, params)
+		require.NoError(t, err)
+
+		response, err := http.Get(presigned.String())
+		require.NoError(t, err)
+		defer func() { require.NoError(t, response.Body.Close()) }()
+
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, `attachment; filename="report.csv"`, response.Header.Get("Content-Disposition"))
+		require.Equal(t, "text/csv", response.Header.Get("Content-Type"))
+		require.Equal(t, "no-cache", response.Header.Get("Cache-Control"))
+
+		body, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.Equal(t, data, body)
+
+		// without the overrides the stored content type is returned
+		presigned, err = client.API.PresignedGetObject(bucket, objectName, time.Hour, nil)
+		require.NoError(t, err)
+
+		response2, err := http.Get(presigned.String())
+		require.NoError(t, err)
+		require.NoError(t, response2.Body.Close())
+		require.Equal(t, "application/octet-stream", response2.Header.Get("Content-Type"))
+		require.Empty(t, response2.Header.Get("Content-Disposition"))
+	})
+}
+
+// trackingReader counts how many bytes were read from the reader.
+type trackingReader struct {
+	read   int64
+	reader io.Reader
+}
+
+func (tracking *trackingReader) Read(p []byte) (n int, err error) {
+	n, err = tracking.reader.Read(p)
+	atomic.AddInt64(&tracking.read, int64(n))
+	return n, err
+}
+
+type testGateway struct {
+	Address   string
+	AccessKey string
+	SecretKey string
+}
+
+// newRequest creates a request signed for the gateway without signing the payload.
+func (gateway testGateway) newRequest(method, path string, body io.Reader, size int64) (*http.Request, error) {
+	request, err := http.NewRequest(method, "http://"+gateway.Address+path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = size
+	request.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	return signer.SignV4(*request, gateway.AccessKey, gateway.SecretKey, "", "us-east-1"), nil
+}
+
+// sendWithStrayBody sends request with "Content-Length: 0" followed by body
+// over a new connection and reads the response.
+func sendWithStrayBody(address string, request *http.Request, body []byte) (_ *http.Response, err error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, conn.Close()) }()
+
+	err = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	request.ContentLength = 0
+	err = request.Write(conn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(ioutil.Discard, response.Body)
+	return response, errs.Combine(err, response.Body.Close())
+}
+
+// newClient creates a minio client connected to the gateway.
+// newPostRequest creates a POST object request with the form fields followed
+// by the file.
+func newPostRequest(target string, fields map[string]string, file []byte) (*http.Request, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	part, err := form.CreateFormFile("file", "file")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(file); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, target, &body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	return request, nil
+}
+
+func (gateway testGateway) newClient() (*minioclient.Minio, error) {
+	client, err := minioclient.NewMinio(minioclient.Config{
+		S3Gateway: gateway.Address,
+		AccessKey: gateway.AccessKey,
+		SecretKey: gateway.SecretKey,
+		NoSSL:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*minioclient.Minio), nil
+}
+
+// runGatewayTest runs the test against a gateway process started with the
+// given flags and connected to a test planet.
+func runGatewayTest(t *testing.T, test func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet, gateway testGateway), moreFlags ...string) {
+	testplanet.Run(t, testplanet.Config{
+		SatelliteCount: 1, StorageNodeCount: 4, UplinkCount: 1,
+		NonParallel: true,
+	}, func(t *testing.T, ctx *testcontext.Context, planet *testplanet.Planet) {
+		uplinkCfg := planet.Uplinks[0].GetConfig(planet.Satellites[0])
+		oldAccess, err := uplinkCfg.GetAccess()
+		require.NoError(t, err)
+
+		// TODO fix this in storj/storj
+		oldAccess.SatelliteAddr = planet.Satellites[0].URL()
+
+		access, err := oldAccess.Serialize()
+		require.NoError(t, err)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		gateway := testGateway{
+			Address:   listener.Addr().String(),
+			AccessKey: base58.Encode(testrand.BytesInt(20)),
+			SecretKey: base58.Encode(testrand.BytesInt(20)),
+		}
+		require.NoError(t, listener.Close())
+
+		gatewayExe := ctx.Compile("storj.io/gateway")
+		process, err := startGateway(t, ctx, gatewayExe, access, gateway.Address, gateway.AccessKey, gateway.SecretKey, moreFlags...)
+		require.NoError(t, err)
+		defer func() { processgroup.Kill(process) }()
+
+		test(t, ctx, planet, gateway)
+	})
+}
+
 func startGateway(t *testing.T, ctx *testcontext.Context, exe, access, address, accessKey, secretKey string, moreFlags ...string) (*exec.Cmd, error) {
 	args := append([]string{"run",
 		"--config-dir", ctx.Dir("gateway"),
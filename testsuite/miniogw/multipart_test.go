@@ -0,0 +1,511 @@
+// Copyright (C) 2020 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package miniogw_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	miniov6 "github.com/minio/minio-go/v6"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/storj"
+	"storj.io/common/testrand"
+	"storj.io/gateway/miniogw"
+	"storj.io/uplink/private/metainfo/kvmetainfo"
+	"storj.io/uplink/private/storage/streams"
+)
+
+func TestListMultipartUploads(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		objects := []string{"a", "b/1", "b/2", "c", "d", "e"}
+		uploadIDs := map[string]string{}
+		for _, object := range objects {
+			uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, object, minio.ObjectOptions{})
+			require.NoError(t, err)
+			uploadIDs[object] = uploadID
+		}
+		defer func() {
+			for object, uploadID := range uploadIDs {
+				assert.NoError(t, layer.AbortMultipartUpload(ctx, TestBucket, object, uploadID))
+			}
+		}()
+
+		// page through all uploads two at a time
+		var listed []string
+		keyMarker, uploadIDMarker := "", ""
+		for pages := 0; ; pages++ {
+			require.True(t, pages < len(objects), "too many pages")
+
+			list, err := layer.ListMultipartUploads(ctx, TestBucket, "", keyMarker, uploadIDMarker, "", 2)
+			require.NoError(t, err)
+			require.True(t, len(list.Uploads) <= 2)
+
+			for _, upload := range list.Uploads {
+				assert.Equal(t, uploadIDs[upload.Object], upload.UploadID)
+				listed = append(listed, upload.Object)
+			}
+			if !list.IsTruncated {
+				assert.Empty(t, list.NextKeyMarker)
+				assert.Empty(t, list.NextUploadIDMarker)
+				break
+			}
+			keyMarker, uploadIDMarker = list.NextKeyMarker, list.NextUploadIDMarker
+		}
+		assert.Equal(t, objects, listed)
+
+		// page through uploads with a delimiter, rolling up "b/" into a prefix
+		var prefixes []string
+		listed = nil
+		keyMarker, uploadIDMarker = "", ""
+		for pages := 0; ; pages++ {
+			require.True(t, pages < len(objects), "too many pages")
+
+			list, err := layer.ListMultipartUploads(ctx, TestBucket, "", keyMarker, uploadIDMarker, "/", 2)
+			require.NoError(t, err)
+			require.True(t, len(list.Uploads)+len(list.CommonPrefixes) <= 2)
+
+			for _, upload := range list.Uploads {
+				listed = append(listed, upload.Object)
+			}
+			prefixes = append(prefixes, list.CommonPrefixes...)
+			if !list.IsTruncated {
+				break
+			}
+			keyMarker, uploadIDMarker = list.NextKeyMarker, list.NextUploadIDMarker
+		}
+		assert.Equal(t, []string{"a", "c", "d", "e"}, listed)
+		assert.Equal(t, []string{"b/"}, prefixes)
+
+		// list with a prefix
+		list, err := layer.ListMultipartUploads(ctx, TestBucket, "b/", "", "", "/", 1)
+		require.NoError(t, err)
+		require.Len(t, list.Uploads, 1)
+		assert.Equal(t, "b/1", list.Uploads[0].Object)
+		assert.True(t, list.IsTruncated)
+
+		list, err = layer.ListMultipartUploads(ctx, TestBucket, "b/", list.NextKeyMarker, list.NextUploadIDMarker, "/", 1)
+		require.NoError(t, err)
+		require.Len(t, list.Uploads, 1)
+		assert.Equal(t, "b/2", list.Uploads[0].Object)
+		assert.False(t, list.IsTruncated)
+	})
+}
+
+func TestGetObjectInfoMultipart(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		var parts []minio.CompletePart
+		var partMD5s []byte
+		for i, size := range []int{100, 200, 50} {
+			data := testrand.BytesInt(size)
+			sum := md5.Sum(data)
+			partMD5s = append(partMD5s, sum[:]...)
+
+			hashReader, err := hash.NewReader(bytes.NewReader(data), int64(size), "", "", int64(size), true)
+			require.NoError(t, err)
+
+			info, err := layer.PutObjectPart(ctx, TestBucket, TestFile, uploadID, i+1, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			require.NoError(t, err)
+			parts = append(parts, minio.CompletePart{PartNumber: info.PartNumber, ETag: info.ETag})
+		}
+
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, parts, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		sum := md5.Sum(partMD5s)
+		info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(sum[:])+"-3", info.ETag)
+		assert.Equal(t, int64(350), info.Size)
+		assert.Equal(t, []minio.ObjectPartInfo{
+			{Number: 1, Size: 100, ActualSize: 100},
+			{Number: 2, Size: 200, ActualSize: 200},
+			{Number: 3, Size: 50, ActualSize: 50},
+		}, info.Parts)
+
+		// Copies are stored at once
+		copied, err := layer.CopyObject(ctx, TestBucket, TestFile, TestBucket, TestFile3, info, minio.ObjectOptions{}, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, copied.Parts)
+
+		// Objects uploaded at once have no parts
+		_, err = putObject(ctx, layer, TestBucket, TestFile2, []byte("test"), nil)
+		require.NoError(t, err)
+
+		info, err = layer.GetObjectInfo(ctx, TestBucket, TestFile2, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.NotContains(t, info.ETag, "-")
+		assert.Empty(t, info.Parts)
+	})
+}
+
+func TestCompleteMultipartUploadPartNumberGaps(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{AllowPartNumberGaps: true}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		var parts []minio.CompletePart
+		var content []byte
+		var partMD5s []byte
+		for _, number := range []int{1, 5, 9} {
+			data := testrand.BytesInt(100 * number)
+			content = append(content, data...)
+			sum := md5.Sum(data)
+			partMD5s = append(partMD5s, sum[:]...)
+
+			hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)), true)
+			require.NoError(t, err)
+
+			info, err := layer.PutObjectPart(ctx, TestBucket, TestFile, uploadID, number, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, number, info.PartNumber)
+			parts = append(parts, minio.CompletePart{PartNumber: info.PartNumber, ETag: info.ETag})
+		}
+
+		// Parts lower than the last streamed part are rejected
+		hashReader, err := hash.NewReader(bytes.NewReader([]byte("test")), 4, "", "", 4, true)
+		require.NoError(t, err)
+		_, err = layer.PutObjectPart(ctx, TestBucket, TestFile, uploadID, 2, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+		require.Error(t, err)
+
+		objInfo, err := layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, parts, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		sum := md5.Sum(partMD5s)
+		assert.Equal(t, hex.EncodeToString(sum[:])+"-3", objInfo.ETag)
+
+		info, err := layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, objInfo.ETag, info.ETag)
+		assert.Equal(t, []minio.ObjectPartInfo{
+			{Number: 1, Size: 100, ActualSize: 100},
+			{Number: 5, Size: 500, ActualSize: 500},
+			{Number: 9, Size: 900, ActualSize: 900},
+		}, info.Parts)
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, content, buf.Bytes())
+	})
+}
+
+func TestCompleteMultipartUploadInvalidParts(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{MaxParts: 2}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		putPart := func(uploadID string, number int) (minio.CompletePart, error) {
+			data := testrand.BytesInt(100)
+			hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)), true)
+			if err != nil {
+				return minio.CompletePart{}, err
+			}
+			info, err := layer.PutObjectPart(ctx, TestBucket, TestFile, uploadID, number, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			return minio.CompletePart{PartNumber: info.PartNumber, ETag: info.ETag}, err
+		}
+
+		assertCleanedUp := func(uploadID string) {
+			list, err := layer.ListMultipartUploads(ctx, TestBucket, "", "", "", "", 10)
+			require.NoError(t, err)
+			assert.Empty(t, list.Uploads)
+
+			_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+			assert.Equal(t, minio.ObjectNotFound{Bucket: TestBucket, Object: TestFile}, err)
+
+			_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, nil, minio.ObjectOptions{})
+			assert.Equal(t, minio.InvalidUploadID{Bucket: TestBucket, Object: TestFile, UploadID: uploadID}, err)
+		}
+
+		// Duplicate part numbers are rejected
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		var parts []minio.CompletePart
+		for _, number := range []int{1, 2} {
+			part, err := putPart(uploadID, number)
+			require.NoError(t, err)
+			parts = append(parts, part)
+		}
+
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, []minio.CompletePart{parts[0], parts[0]}, minio.ObjectOptions{})
+		assert.Equal(t, "InvalidPartOrder", miniov6.ToErrorResponse(err).Code)
+
+		// as are parts that weren't uploaded, and the upload is kept to
+		// complete it with the right parts
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, []minio.CompletePart{parts[0], {PartNumber: 2, ETag: parts[0].ETag}}, minio.ObjectOptions{})
+		assert.IsType(t, minio.InvalidPart{}, err)
+
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, parts[:1], minio.ObjectOptions{})
+		assert.IsType(t, minio.InvalidPart{}, err)
+
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, parts, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		_, err = layer.GetObjectInfo(ctx, TestBucket, TestFile, minio.ObjectOptions{})
+		require.NoError(t, err)
+		err = layer.DeleteObject(ctx, TestBucket, TestFile)
+		require.NoError(t, err)
+
+		// More parts than the maximum are rejected on completion
+		uploadID, err = layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		parts = nil
+		for _, number := range []int{1, 2} {
+			part, err := putPart(uploadID, number)
+			require.NoError(t, err)
+			parts = append(parts, part)
+		}
+
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, append(parts, minio.CompletePart{PartNumber: 3}), minio.ObjectOptions{})
+		assert.Equal(t, "InvalidRequest", miniov6.ToErrorResponse(err).Code)
+		assertCleanedUp(uploadID)
+
+		// and when they are uploaded
+		uploadID, err = layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		for _, number := range []int{1, 2} {
+			_, err := putPart(uploadID, number)
+			require.NoError(t, err)
+		}
+
+		_, err = putPart(uploadID, 3)
+		assert.Equal(t, "InvalidRequest", miniov6.ToErrorResponse(err).Code)
+		assertCleanedUp(uploadID)
+	})
+}
+
+func TestGetObjectPartRange(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{AllowPartNumberGaps: true}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		var parts []minio.CompletePart
+		content := map[int][]byte{}
+		for _, part := range []struct{ number, size int }{{1, 100}, {5, 200}, {9, 50}} {
+			data := testrand.BytesInt(part.size)
+			content[part.number] = data
+
+			hashReader, err := hash.NewReader(bytes.NewReader(data), int64(part.size), "", "", int64(part.size), true)
+			require.NoError(t, err)
+
+			info, err := layer.PutObjectPart(ctx, TestBucket, TestFile, uploadID, part.number, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			require.NoError(t, err)
+			parts = append(parts, minio.CompletePart{PartNumber: info.PartNumber, ETag: info.ETag})
+		}
+
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, parts, minio.ObjectOptions{})
+		require.NoError(t, err)
+
+		read := func(rangeSpec *minio.HTTPRangeSpec, partNumber int) ([]byte, error) {
+			reader, err := layer.GetObjectNInfo(ctx, TestBucket, TestFile, rangeSpec, nil, 0, minio.ObjectOptions{PartNumber: partNumber})
+			if err != nil {
+				return nil, err
+			}
+			defer func() { _ = reader.Close() }()
+			return ioutil.ReadAll(reader)
+		}
+
+		// The range is relative to part 5, which starts after the 100 bytes
+		// of part 1, and is rewritten relative to the whole object
+		rangeSpec := &minio.HTTPRangeSpec{Start: 10, End: 19}
+		data, err := read(rangeSpec, 5)
+		require.NoError(t, err)
+		assert.Equal(t, content[5][10:20], data)
+		assert.Equal(t, &minio.HTTPRangeSpec{Start: 110, End: 119}, rangeSpec)
+
+		// Suffix ranges end at the end of the part
+		rangeSpec = &minio.HTTPRangeSpec{IsSuffixLength: true, Start: -5}
+		data, err = read(rangeSpec, 5)
+		require.NoError(t, err)
+		assert.Equal(t, content[5][195:], data)
+		assert.Equal(t, &minio.HTTPRangeSpec{Start: 295, End: 299}, rangeSpec)
+
+		// Without a range the whole part is read
+		data, err = read(nil, 9)
+		require.NoError(t, err)
+		assert.Equal(t, content[9], data)
+
+		// Invalid combinations are rejected
+		for _, invalid := range []struct {
+			rangeSpec  *minio.HTTPRangeSpec
+			partNumber int
+		}{
+			{nil, 2},
+			{&minio.HTTPRangeSpec{Start: 0, End: 9}, 10},
+			{&minio.HTTPRangeSpec{Start: 200, End: 209}, 5},
+		} {
+			_, err = read(invalid.rangeSpec, invalid.partNumber)
+			assert.Equal(t, "InvalidArgument", miniov6.ToErrorResponse(err).Code)
+		}
+	})
+}
+
+func TestCompleteMultipartUploadConcurrently(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		data := testrand.BytesInt(100)
+		hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)), true)
+		require.NoError(t, err)
+
+		info, err := layer.PutObjectPart(ctx, TestBucket, TestFile, uploadID, 1, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+		require.NoError(t, err)
+		parts := []minio.CompletePart{{PartNumber: info.PartNumber, ETag: info.ETag}}
+
+		var wg sync.WaitGroup
+		errors := make([]error, 2)
+		for i := range errors {
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errors[i] = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, parts, minio.ObjectOptions{})
+			}()
+		}
+		wg.Wait()
+
+		// exactly one of the completions succeeds
+		if errors[0] == nil {
+			errors[0], errors[1] = errors[1], errors[0]
+		}
+		assert.Equal(t, minio.InvalidUploadID{Bucket: TestBucket, Object: TestFile, UploadID: uploadID}, errors[0])
+		assert.NoError(t, errors[1])
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, data, buf.Bytes())
+	})
+}
+
+func TestDeleteBucketPendingMultipartUpload(t *testing.T) {
+	runTest(t, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		// The bucket isn't deleted while an upload is pending
+		err = layer.DeleteBucket(ctx, TestBucket, false)
+		assert.Equal(t, minio.BucketNotEmpty{Bucket: TestBucket}, err)
+
+		_, err = layer.GetBucketInfo(ctx, TestBucket)
+		require.NoError(t, err)
+
+		require.NoError(t, layer.AbortMultipartUpload(ctx, TestBucket, TestFile, uploadID))
+		require.NoError(t, layer.DeleteBucket(ctx, TestBucket, false))
+	})
+
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{AbortUploadsOnBucketDelete: true}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		// The pending upload is aborted with the bucket deletion
+		require.NoError(t, layer.DeleteBucket(ctx, TestBucket, false))
+
+		_, err = layer.GetBucketInfo(ctx, TestBucket)
+		assert.Equal(t, minio.BucketNotFound{Bucket: TestBucket}, err)
+
+		list, err := layer.ListMultipartUploads(ctx, TestBucket, "", "", "", "", 10)
+		require.NoError(t, err)
+		assert.Empty(t, list.Uploads)
+
+		_, err = layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, nil, minio.ObjectOptions{})
+		assert.Equal(t, minio.InvalidUploadID{Bucket: TestBucket, Object: TestFile, UploadID: uploadID}, err)
+	})
+
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{AbortUploadsOnBucketDelete: true}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		_, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		_, err = putObject(ctx, layer, TestBucket, TestFile2, []byte("test"), nil)
+		require.NoError(t, err)
+
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		// The pending upload isn't aborted if the bucket isn't empty
+		err = layer.DeleteBucket(ctx, TestBucket, false)
+		assert.Equal(t, minio.BucketNotEmpty{Bucket: TestBucket}, err)
+
+		list, err := layer.ListMultipartUploads(ctx, TestBucket, "", "", "", "", 10)
+		require.NoError(t, err)
+		require.Len(t, list.Uploads, 1)
+		assert.Equal(t, uploadID, list.Uploads[0].UploadID)
+	})
+}
+
+func TestCompleteMultipartUploadTransform(t *testing.T) {
+	runTestWithConfig(t, storj.EncNull, miniogw.Config{
+		Transforms: []miniogw.Transform{keyTransform{}},
+	}, func(t *testing.T, ctx context.Context, layer minio.ObjectLayer, m *kvmetainfo.DB, strms streams.Store) {
+		testBucketInfo, err := m.CreateBucket(ctx, TestBucket, nil)
+		require.NoError(t, err)
+
+		uploadID, err := layer.NewMultipartUpload(ctx, TestBucket, TestFile, minio.ObjectOptions{UserDefined: map[string]string{}})
+		require.NoError(t, err)
+
+		var parts []minio.CompletePart
+		var content []byte
+		for number := 1; number <= 2; number++ {
+			data := testrand.BytesInt(1000)
+			content = append(content, data...)
+
+			hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)), true)
+			require.NoError(t, err)
+
+			info, err := layer.PutObjectPart(ctx, TestBucket, TestFile, uploadID, number, minio.NewPutObjReader(hashReader, nil, nil), minio.ObjectOptions{})
+			require.NoError(t, err)
+			parts = append(parts, minio.CompletePart{PartNumber: info.PartNumber, ETag: info.ETag})
+		}
+
+		objInfo, err := layer.CompleteMultipartUpload(ctx, TestBucket, TestFile, uploadID, parts, minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "true", objInfo.UserDefined["s3:transformed"])
+
+		// The stored content is transformed and read back transparently
+		obj, err := m.GetObject(ctx, testBucketInfo, TestFile)
+		require.NoError(t, err)
+		assert.Equal(t, "true", obj.Metadata["s3:transformed"])
+
+		var buf bytes.Buffer
+		err = layer.GetObject(ctx, TestBucket, TestFile, 0, -1, &buf, "", minio.ObjectOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, content, buf.Bytes())
+	})
+}